@@ -5,4 +5,5 @@ go 1.14
 require (
 	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815
 	github.com/olekukonko/tablewriter v0.0.4
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
@@ -17,7 +18,6 @@ import (
 
 	"github.com/DanielOaks/irc-stress-test/stress"
 	"github.com/docopt/docopt-go"
-	"github.com/olekukonko/tablewriter"
 )
 
 func startPprof(port string) {
@@ -31,6 +31,27 @@ func startPprof(port string) {
 	}()
 }
 
+// parseDuration parses the given duration argument, exiting if it's invalid.
+func parseDuration(arguments map[string]interface{}, name string) time.Duration {
+	duration, err := time.ParseDuration(arguments[name].(string))
+	if err != nil || duration <= 0 {
+		log.Fatal("Invalid duration for ", name, ": ", arguments[name].(string))
+	}
+	return duration
+}
+
+// addLine adds the given line to the event queue, waiting for the server's
+// response afterwards if wait is true.
+func addLine(events *stress.EventQueue, line string, wait bool) {
+	events.Events = append(events.Events, stress.Event{
+		Type: stress.ETLine,
+		Line: line,
+	})
+	if wait {
+		events.Events = append(events.Events, stress.WaitsAfter(line)...)
+	}
+}
+
 func main() {
 	usage := `ircstress.
 ircstress is intended to stress an IRC server through connect flooding, channel message flooding,
@@ -38,21 +59,63 @@ client message flooding and 'regular' IRC client operations. It is primarily int
 during the development of IRC servers and to compare how well servers perform under load.
 
 Usage:
-	ircstress connectflood [--nicks=<file>] [--random-nicks] [--clients=<num>] [--queues=<num>] [--wait] [--pprof-port=<num>] <server-details>...
-	ircstress chanflood [--nicks=<file>] [--random-nicks] [--clients=<num>] [--queues=<num>] [--wait] [--chan=<name>] [--floodsize=<num>] [--pprof-port=<num>] <server-details>...
+	ircstress connectflood [options] <server-details>...
+	ircstress chanflood [options] <server-details>...
+	ircstress run [options] <scenario> <server-details>...
 	ircstress -h | --help
 	ircstress --version
 
 Options:
 	--nicks=<file>     List to grab nicks from, separated by newlines [default: use counter].
 	--random-nicks     If nicklist is given, randomise order of used nicks.
-	--clients=<num>    The number of clients that should connect [default: 10000].
-	--chan=<name>      Channel name to join [default: #test].
-	--floodsize=<num>  Number of messages to flood with during chanflood [default: 1]
-
-	--queues=<num>     How many queues to run events on, limited to number of clients [default: 3].
+	--nick-retries=<num>  How many new nicks to try when ours is in use [default: 3].
+	--nick-fallback=<strategy>  Without a nicklist, how to pick a new nick when ours is in use:
+	                   underscore, counter or random [default: underscore].
+	--caps=<list>      Comma-separated IRCv3 capabilities to request during registration,
+	                   e.g. message-tags,server-time,echo-message,batch [default: none].
+	--sasl=<mech>      Authenticate with SASL during registration, PLAIN or EXTERNAL [default: none].
+	--sasl-creds=<pattern>  PLAIN credentials as account:password, where each %d is replaced
+	                   with the client number [default: user%d:pass%d].
+	--sasl-creds-file=<file>  File of PLAIN credentials, one account:password per line, used
+	                   instead of --sasl-creds.
+	--tls-cert=<file>  TLS client certificate to connect with, used by SASL EXTERNAL.
+	--tls-key=<file>   Private key for --tls-cert.
+	--clients=<num>    The number of clients that should connect, 10000 if not given.
+	--chan=<name>      Channel name to join, #test if not given.
+	--floodsize=<num>  Number of messages to flood with during chanflood, 1 if not given.
+	--flood-text=<template>  Text of each chanflood message, which may use template variables
+	                   like {nick}, {seq} and {random_text:50-400}, "Test string {seq} to flood
+	                   with here" if not given.
+	--queues=<num>     How many queues to run events on, limited to number of clients. Each queue
+	                   runs its clients' events one at a time, 0 runs one queue per client [default: 0].
+	--ramp=<profile>   How quickly to start clients: rate:<conns-per-second>, linear:<duration>,
+	                   step:<clients>/<interval> or burst. Overrides the scenario's ramp, and
+	                   is rate:333 if neither gives one.
+	--open-loop=<rate>  Send each client's lines and pings at this fixed rate per second, no matter
+	                   how quickly the server responds, and measure latencies from when they were
+	                   meant to be sent. Requires one queue per client.
+	--runs=<num>       How many times to test each server, reporting statistics across the runs [default: 1].
+	--seed=<num>       Seed for every random choice: nick order, template variables, sleep jitter
+	                   and scenario choices. Picked at random if not given, and always reported.
 	--wait             After each action, waits for server response before continuing.
+	                   Like --clients, --chan, --floodsize and --flood-text, this can't be used
+	                   with run, where the scenario sets them.
+	--timeout-connect=<duration>  How long to wait for each connection to open [default: 10s].
+	--timeout-handshake=<duration>  How long to wait for each TLS handshake [default: 5s].
+	--timeout-registration=<duration>  How long to wait for each client to register [default: 30s].
+	--timeout-wait=<duration>  How long to wait for each expected server response [default: 30s].
+	--timeout-ping=<duration>  How long to wait for each PONG [default: 30s].
+	--timeout-write=<duration>  How long each write to the server may take [default: 30s].
+	--timeout-quit=<duration>  How long to wait for the server to close our connection after
+	                   QUIT [default: 30s].
+	--output=<format>  How to write results: table, json, csv or markdown [default: table].
+	--output-file=<file>  Write results to this file instead of stdout.
+	--baseline=<file>  JSON results from an earlier run to compare against, matching servers by name.
+	--max-regression=<list>  Comma-separated metric=percent limits on how much worse than the
+	                   baseline each metric may be, e.g. "Registration p99=10%,*Mean=20%".
+	--min-success-rate=<percent>  Fail if fewer than this percent of clients succeed [default: 0].
 	--pprof-port=<num>     Start a pprof http endpoint for ircstress on this port
+	<scenario>         YAML or JSON scenario file to run, see README.md.
 	<server-details>   Set of server details, of the format: "Name,Addr,TLS", where Addr is like "localhost:6667" and TLS is either "yes" or "no".
 
 	-h --help          Show this screen.
@@ -60,11 +123,47 @@ Options:
 
 Examples:
 	go run ircstress.go chanflood --clients=2000 --wait local,localhost:6667,no
-		Tests a local server with 2000 clients, connecting to channel #test.`
+		Tests a local server with 2000 clients, connecting to channel #test.
+	go run ircstress.go run scenarios/chanflood.yaml local,localhost:6667,no
+		Runs the clients and scripts described in the given scenario file.`
 
 	arguments, _ := docopt.Parse(usage, nil, true, stress.SemVer, false)
 
-	if arguments["connectflood"].(bool) || arguments["chanflood"].(bool) {
+	if arguments["connectflood"].(bool) || arguments["chanflood"].(bool) || arguments["run"].(bool) {
+		// one seed for every random choice, so runs can be repeated
+		seed := time.Now().UnixNano()
+		if arguments["--seed"] != nil {
+			var err error
+			seed, err = strconv.ParseInt(arguments["--seed"].(string), 10, 64)
+			if err != nil {
+				log.Fatal("Invalid seed:", arguments["--seed"].(string))
+			}
+		}
+
+		// scenarios set these themselves, so they're only defaulted for the
+		// built-in tests
+		builtinOptions := []struct {
+			name  string
+			value string
+		}{
+			{"--clients", "10000"},
+			{"--chan", "#test"},
+			{"--floodsize", "1"},
+			{"--flood-text", "Test string {seq} to flood with here"},
+		}
+		for _, option := range builtinOptions {
+			if arguments["run"].(bool) {
+				if arguments[option.name] != nil {
+					log.Fatal(option.name, " can't be used with run, the scenario sets it")
+				}
+			} else if arguments[option.name] == nil {
+				arguments[option.name] = option.value
+			}
+		}
+		if arguments["run"].(bool) && arguments["--wait"].(bool) {
+			log.Fatal("--wait can't be used with run, use wait_reply and wait_registered in the scenario")
+		}
+
 		// get nicks
 		var ns *stress.NickSelector
 		if arguments["--nicks"].(string) == "use counter" {
@@ -76,27 +175,90 @@ Examples:
 				log.Fatal("Could not load nickList:", err.Error())
 			}
 			ns = stress.NickSelectorFromList(string(listBytes))
+			ns.Seed(seed)
 			if arguments["--random-nicks"].(bool) {
 				ns.RandomNickOrder = true
 			}
 		}
 
+		nickRetries, err := strconv.Atoi(arguments["--nick-retries"].(string))
+		if err != nil || nickRetries < 0 {
+			log.Fatal("Invalid number of nick retries:", arguments["--nick-retries"].(string))
+		}
+		nickFallback, err := stress.NickFallbackFromString(arguments["--nick-fallback"].(string))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		var caps []string
+		if arguments["--caps"].(string) != "none" {
+			for _, name := range strings.Split(arguments["--caps"].(string), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					caps = append(caps, name)
+				}
+			}
+		}
+
+		// load sasl details
+		var saslMech *stress.SASLMechanism
+		if arguments["--sasl"].(string) != "none" {
+			mech, err := stress.SASLMechanismFromString(arguments["--sasl"].(string))
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			saslMech = &mech
+		}
+		var saslCreds []stress.SASLCredentials
+		if arguments["--sasl-creds-file"] != nil {
+			saslCreds, err = stress.LoadSASLCredentials(arguments["--sasl-creds-file"].(string))
+			if err != nil {
+				log.Fatal("Could not load SASL credentials:", err.Error())
+			}
+		}
+		var certificate *tls.Certificate
+		if arguments["--tls-cert"] != nil {
+			if arguments["--tls-key"] == nil {
+				log.Fatal("--tls-cert requires --tls-key")
+			}
+			cert, err := tls.LoadX509KeyPair(arguments["--tls-cert"].(string), arguments["--tls-key"].(string))
+			if err != nil {
+				log.Fatal("Could not load TLS client certificate:", err.Error())
+			}
+			certificate = &cert
+		}
+		if saslMech != nil && *saslMech == stress.SASLExternal && certificate == nil {
+			log.Fatal("SASL EXTERNAL requires --tls-cert and --tls-key")
+		}
+
 		port := arguments["--pprof-port"]
 		if port != nil {
 			startPprof(port.(string))
 		}
 
+		// results output
+		outputFormat, err := stress.OutputFormatFromString(arguments["--output"].(string))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		outputFile := arguments["--output-file"]
+		// keep stdout clean for machine-readable results
+		progress := os.Stdout
+		if outputFormat != stress.OFTable && outputFile == nil {
+			progress = os.Stderr
+		}
+
 		// run string
+		wait := arguments["--wait"].(bool)
 		var optionString string
-		if !arguments["--wait"].(bool) {
+		if !wait {
 			optionString += "not "
 		}
 		optionString += "waiting"
 
-		fmt.Println(fmt.Sprintf("Running tests (%s)", optionString))
+		fmt.Fprintln(progress, fmt.Sprintf("Running tests (%s)", optionString))
 
 		// assemble each server's details
-		servers := make(map[string]*stress.Server)
+		var servers []*stress.Server
 		for _, serverString := range arguments["<server-details>"].([]string) {
 			serverList := strings.Split(serverString, ",")
 			if len(serverList) != 3 {
@@ -120,34 +282,99 @@ Examples:
 				},
 			}
 
-			fmt.Println("Testing server", newServer.Name, "at", newServer.Conn.Address)
+			fmt.Fprintln(progress, "Testing server", newServer.Name, "at", newServer.Conn.Address)
+
+			servers = append(servers, &newServer)
+		}
+
+		timeouts := stress.Timeouts{
+			Connect:      parseDuration(arguments, "--timeout-connect"),
+			Handshake:    parseDuration(arguments, "--timeout-handshake"),
+			Registration: parseDuration(arguments, "--timeout-registration"),
+			Wait:         parseDuration(arguments, "--timeout-wait"),
+			Ping:         parseDuration(arguments, "--timeout-ping"),
+			Write:        parseDuration(arguments, "--timeout-write"),
+			Quit:         parseDuration(arguments, "--timeout-quit"),
+		}
+		for _, server := range servers {
+			server.Timeouts = timeouts
+		}
+
+		var clientCount int
+		if arguments["--clients"] != nil {
+			clientCount, err = strconv.Atoi(arguments["--clients"].(string))
+			if err != nil || clientCount < 1 {
+				log.Fatal("Invalid number of clients:", arguments["--clients"].(string))
+			}
+		}
+
+		runs, err := strconv.Atoi(arguments["--runs"].(string))
+		if err != nil || runs < 1 {
+			log.Fatal("Invalid number of runs:", arguments["--runs"].(string))
+		}
 
-			servers[newServer.Name] = &newServer
+		queueCount, err := strconv.Atoi(arguments["--queues"].(string))
+		if err != nil || queueCount < 0 {
+			log.Fatal("Invalid number of queues:", arguments["--queues"].(string))
 		}
 
-		clientCount, err := strconv.Atoi(arguments["--clients"].(string))
-		if err != nil || clientCount < 1 {
-			log.Fatal("Invalid number of clients:", arguments["--clients"].(string))
+		var pace time.Duration
+		if arguments["--open-loop"] != nil {
+			rate, err := strconv.ParseFloat(arguments["--open-loop"].(string), 64)
+			if err != nil || rate <= 0 {
+				log.Fatal("Invalid open-loop rate:", arguments["--open-loop"].(string))
+			}
+			if queueCount != 0 {
+				log.Fatal("--open-loop requires one queue per client, --queues=0")
+			}
+			pace = time.Duration(float64(time.Second) / rate)
 		}
 
 		// create event queues
-		eventQueues := make([]stress.EventQueue, clientCount)
-		var deliberateDisconnects int
+		var eventQueues []*stress.EventQueue
+		var scenario *stress.Scenario
+		if arguments["run"].(bool) {
+			scenario, err = stress.LoadScenario(arguments["<scenario>"].(string))
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			eventQueues, err = scenario.EventQueues(seed, ns)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			clientCount = len(eventQueues)
+		} else {
+			eventQueues = make([]*stress.EventQueue, clientCount)
+		}
 
-		var floodLines []string
-		if arguments["chanflood"].(bool) {
-			floodCount, err := strconv.Atoi(arguments["--floodsize"].(string))
+		// --ramp overrides the scenario's ramp, and is recorded in the report
+		// either way
+		if arguments["--ramp"] == nil {
+			arguments["--ramp"] = "rate:333"
+			if scenario != nil && scenario.Ramp != "" {
+				arguments["--ramp"] = scenario.Ramp
+			}
+		}
+		ramp, err := stress.ParseRampProfile(arguments["--ramp"].(string))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		var channelName, floodText string
+		var floodCount int
+		if scenario == nil {
+			channelName = arguments["--chan"].(string)
+			floodCount, err = strconv.Atoi(arguments["--floodsize"].(string))
 			if err != nil {
 				floodCount = 1
 			}
-			floodLines = make([]string, floodCount)
-			channelName :=arguments["--chan"].(string)
-			for i := 0; i < len(floodLines); i++ {
-				floodLines[i] = fmt.Sprintf("PRIVMSG %s :Test string %d to flood with here\r\n", channelName, i)
+			floodText = arguments["--flood-text"].(string)
+			if err := stress.ValidateTemplate(floodText); err != nil {
+				log.Fatal(err.Error())
 			}
 		}
 
-		for i := 0; i < clientCount; i++ {
+		for i := 0; scenario == nil && i < clientCount; i++ {
 			var newClient *stress.Client
 			if ns == nil {
 				newClient = &stress.Client{
@@ -161,74 +388,224 @@ Examples:
 
 			// for now we'll just have one event list per client for simplicity
 			events := stress.NewEventQueue(i)
+			events.Client.Nick = newClient.Nick
+			events.Client.NickSelector = ns
 			events.Events = append(events.Events, stress.Event{
 				Type: stress.ETConnect,
 			})
 
-			// send NICK+USER
-			// events.Events = append(events.Events, stress.Event{
-			// 	Type:   stress.ETLine,
-			// 	Line:   fmt.Sprintf("CAP END\r\n", newClient.Nick),
-			// })
+			// negotiate caps and send NICK+USER
 			events.Events = append(events.Events, stress.Event{
-				Type: stress.ETLine,
-				Line: fmt.Sprintf("NICK %s\r\n", newClient.Nick),
-			})
-			events.Events = append(events.Events, stress.Event{
-				Type: stress.ETLine,
-				Line: "USER test 0 * :I am a cool person!\r\n",
+				Type: stress.ETRegister,
 			})
+			// chanflood's barriers only mean something once the server has
+			// answered, so it always waits before them
+			if wait || arguments["chanflood"].(bool) {
+				events.Events = append(events.Events, stress.Event{
+					Type: stress.ETWaitRegistered,
+				})
+			}
 
 			if arguments["chanflood"].(bool) {
+				// barriers keep each phase apart, so joining doesn't get
+				// mixed up with flooding
+				events.Events = append(events.Events, stress.Event{
+					Type:    stress.ETBarrier,
+					Barrier: "registered",
+				})
+				addLine(events, fmt.Sprintf("JOIN %s\r\n", channelName), true)
 				events.Events = append(events.Events, stress.Event{
-					Type: stress.ETLine,
-					Line: fmt.Sprintf("JOIN %s\r\n", arguments["--chan"].(string)),
+					Type:    stress.ETBarrier,
+					Barrier: "joined",
 				})
-				for _, line := range floodLines {
+				for j := 0; j < floodCount; j++ {
 					events.Events = append(events.Events, stress.Event{
-						Type: stress.ETLine,
-						Line: line,
+						Type:   stress.ETFlood,
+						Target: channelName,
+						Line:   floodText,
 					})
 				}
+				events.Events = append(events.Events, stress.Event{
+					Type:    stress.ETBarrier,
+					Barrier: "flooded",
+				})
 				events.Events = append(events.Events, stress.Event{
 					Type: stress.ETPing,
 				})
 			}
 
-			//TODO(dan): send NICK/USER
 			events.Events = append(events.Events, stress.Event{
 				Type: stress.ETDisconnect,
 			})
-			deliberateDisconnects++
 
 			eventQueues[i] = events
 		}
 
-		// run for each server
-		for name, server := range servers {
-			fmt.Println("Testing", name)
-			server.ClientsReadyToDisconnect.Add(deliberateDisconnects)
-			server.ClientsFinished.Add(clientCount)
+		// client options shared by every queue
+		var deliberateDisconnects int
+		// how many clients use each barrier, in the order they're used
+		var barrierNames []string
+		barrierClients := make(map[string]int)
+		var requestingCaps, flooding bool
+		for i, events := range eventQueues {
+			events.Pace = pace
+			events.Seed = seed
+			events.Client.NickFallback = nickFallback
+			events.Client.NickRetries = nickRetries
+			if events.Client.Caps == nil {
+				events.Client.Caps = caps
+			}
+			requestingCaps = requestingCaps || len(events.Client.Caps) > 0
+			events.Client.Certificate = certificate
+			if saslMech != nil {
+				var creds stress.SASLCredentials
+				if *saslMech == stress.SASLExternal {
+					// no credentials, the server uses our certificate
+				} else if saslCreds != nil {
+					creds = saslCreds[i%len(saslCreds)]
+				} else {
+					creds, err = stress.SASLCredentialsFromPattern(arguments["--sasl-creds"].(string), i)
+					if err != nil {
+						log.Fatal(err.Error())
+					}
+				}
+				creds.Mechanism = *saslMech
+				events.Client.SASL = &creds
+			}
+
+			for _, event := range events.Events {
+				switch event.Type {
+				case stress.ETDisconnect:
+					deliberateDisconnects++
+				case stress.ETBarrier:
+					if barrierClients[event.Barrier] == 0 {
+						barrierNames = append(barrierNames, event.Barrier)
+					}
+					barrierClients[event.Barrier]++
+				}
+			}
+			flooding = flooding || events.Uses(stress.ETFlood)
+		}
+
+		// pass/fail checks
+		var gate stress.Gate
+		if arguments["--baseline"] != nil {
+			gate.Baseline, err = stress.LoadReport(arguments["--baseline"].(string))
+			if err != nil {
+				log.Fatal("Could not load baseline:", err.Error())
+			}
+		}
+		if arguments["--max-regression"] != nil {
+			if gate.Baseline == nil {
+				log.Fatal("--max-regression requires --baseline")
+			}
+			gate.Thresholds, err = stress.ParseThresholds(arguments["--max-regression"].(string))
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+		}
+		minSuccessRate, err := strconv.ParseFloat(strings.TrimSuffix(arguments["--min-success-rate"].(string), "%"), 64)
+		if err != nil || minSuccessRate < 0 || 100 < minSuccessRate {
+			log.Fatal("Invalid minimum success rate:", arguments["--min-success-rate"].(string))
+		}
+		gate.MinSuccessRate = minSuccessRate / 100
 
-			// start each event queue
-			for _, events := range eventQueues {
-				time.Sleep(time.Millisecond * 3)
-				go events.Run(server)
+		// scenario parameters for the report, leaving out those that don't
+		// change the results, and credentials that shouldn't be published
+		command := "connectflood"
+		if arguments["chanflood"].(bool) {
+			command = "chanflood"
+		} else if scenario != nil {
+			command = "run"
+		}
+		parameters := make(map[string]interface{})
+		for name, value := range arguments {
+			switch name {
+			case "--help", "--version", "--output", "--output-file", "--pprof-port",
+				"--baseline", "--max-regression", "--min-success-rate", "--runs", "--seed",
+				"--sasl-creds", "--sasl-creds-file", "--tls-key":
+				continue
+			}
+			if strings.HasPrefix(name, "--") {
+				parameters[strings.TrimPrefix(name, "--")] = value
 			}
+		}
+		if scenario != nil {
+			parameters["scenario"] = scenario.Name
+		}
+		report := stress.NewReport(command, parameters)
+		report.Runs = runs
+		report.Seed = seed
+		fmt.Fprintln(progress, "Using seed", seed)
+		resultOptions := stress.ResultOptions{
+			Clients: clientCount,
+			Caps:    requestingCaps,
+			SASL:    saslMech != nil,
+			Flood:   flooding,
+		}
+
+		// run for each server
+		for _, details := range servers {
+			for run := 1; run <= runs; run++ {
+				server := details.NewRun()
+				if runs > 1 {
+					fmt.Fprintln(progress, "Testing", server.Name, "with ramp", ramp.String(), fmt.Sprintf("(run %d of %d)", run, runs))
+				} else {
+					fmt.Fprintln(progress, "Testing", server.Name, "with ramp", ramp.String())
+				}
+				server.ClientsReadyToDisconnect.Add(deliberateDisconnects)
+				for _, name := range barrierNames {
+					server.AddBarrier(name, barrierClients[name])
+				}
+				server.ClientsFinished.Add(clientCount)
 
-			// wait for each of them to be finished
-			server.ClientsFinished.Wait()
+				// run each event queue, ramping up as requested
+				started := time.Now()
+				stress.RunWorkers(server, eventQueues, queueCount, ramp)
 
-			data := [][]string{
-				[]string{"Total Clients", strconv.Itoa(clientCount)},
-				[]string{"Successful Clients", strconv.Itoa(int(server.Succeeded()))},
+				// wait for each of them to be finished
+				server.ClientsFinished.Wait()
+
+				result := server.Results(resultOptions, time.Since(started))
+				result.Run = run
+				report.Servers = append(report.Servers, result)
+				if outputFormat == stress.OFTable && outputFile == nil {
+					// tables are written as we go, so they're seen sooner
+					stress.WriteTables(os.Stdout, result)
+				}
 			}
+		}
+		if runs > 1 {
+			report.Summarise()
+		}
+
+		report.Violations = gate.Check(report)
 
-			table := tablewriter.NewWriter(os.Stdout)
-			for _, v := range data {
-				table.Append(v)
+		if outputFormat == stress.OFTable && outputFile == nil {
+			report.WriteStatistics(os.Stdout)
+			report.WriteComparison(os.Stdout)
+			stress.WriteViolations(os.Stdout, report.Violations)
+		} else {
+			output := os.Stdout
+			if outputFile != nil {
+				output, err = os.Create(outputFile.(string))
+				if err != nil {
+					log.Fatal("Could not create output file:", err.Error())
+				}
+			}
+			err = report.Write(output, outputFormat)
+			if err != nil {
+				log.Fatal("Could not write results:", err.Error())
 			}
-			table.Render() // Send output
+			output.Close()
+			if outputFormat != stress.OFTable {
+				stress.WriteViolations(progress, report.Violations)
+			}
+		}
+
+		if len(report.Violations) > 0 {
+			log.Println(len(report.Violations), "checks failed")
+			os.Exit(1)
 		}
 	}
 }
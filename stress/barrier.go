@@ -0,0 +1,159 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"sync"
+	"time"
+)
+
+// barrier is a named sync point. Clients that reach it wait until every
+// client expected to reach it has, or has given up trying.
+type barrier struct {
+	name      string
+	clients   int
+	remaining int
+	first     time.Time
+	released  time.Time
+}
+
+// barrierSet holds a server's barriers, in the order they were added.
+type barrierSet struct {
+	sync.Mutex
+	started time.Time
+	order   []*barrier
+	byName  map[string]*barrier
+	// changed is closed and replaced whenever a barrier is released.
+	changed chan struct{}
+}
+
+// get returns the named barrier, creating it if needed. The set must be
+// locked.
+func (set *barrierSet) get(name string) *barrier {
+	if set.byName == nil {
+		set.byName = make(map[string]*barrier)
+	}
+	b := set.byName[name]
+	if b == nil {
+		b = &barrier{name: name}
+		set.byName[name] = b
+		set.order = append(set.order, b)
+	}
+	return b
+}
+
+// changes returns a channel that's closed the next time a barrier is
+// released. The set must be locked.
+func (set *barrierSet) changes() chan struct{} {
+	if set.changed == nil {
+		set.changed = make(chan struct{})
+	}
+	return set.changed
+}
+
+// AddBarrier expects the given number of clients to reach the named barrier.
+// Like ClientsReadyToDisconnect, this must be done before running clients.
+func (server *Server) AddBarrier(name string, clients int) {
+	set := &server.barriers
+	set.Lock()
+	defer set.Unlock()
+	b := set.get(name)
+	b.clients += clients
+	b.remaining += clients
+}
+
+// startBarriers marks when clients started running, which barrier timings
+// are measured from.
+func (server *Server) startBarriers() {
+	set := &server.barriers
+	set.Lock()
+	defer set.Unlock()
+	if set.started.IsZero() {
+		set.started = time.Now()
+	}
+}
+
+// ReachBarrier records a client reaching the named barrier. Clients that
+// fail before a barrier reach it too, so others don't wait on them forever.
+func (server *Server) ReachBarrier(name string) {
+	set := &server.barriers
+	set.Lock()
+	defer set.Unlock()
+	b := set.get(name)
+	now := time.Now()
+	if b.first.IsZero() {
+		b.first = now
+	}
+	if b.remaining > 0 {
+		b.remaining--
+		if b.remaining == 0 {
+			b.released = now
+			close(set.changes())
+			set.changed = nil
+		}
+	}
+}
+
+// barrierReleased returns true if every client has reached the named
+// barrier, and otherwise a channel that's closed when any barrier is next
+// released.
+func (server *Server) barrierReleased(name string) (bool, chan struct{}) {
+	set := &server.barriers
+	set.Lock()
+	defer set.Unlock()
+	b := set.byName[name]
+	if b == nil || b.remaining == 0 {
+		return true, nil
+	}
+	return false, set.changes()
+}
+
+// BarrierResult holds the timings of one barrier, in milliseconds since
+// clients started running.
+type BarrierResult struct {
+	Name    string `json:"name"`
+	Clients int    `json:"clients"`
+	// Released is false if some clients never reached the barrier, in which
+	// case All and Phase aren't set.
+	Released bool    `json:"released"`
+	First    float64 `json:"first_ms"`
+	All      float64 `json:"all_ms"`
+	// Phase is how long it took every client to get here from the previous
+	// barrier, or from the start for the first one.
+	Phase float64 `json:"phase_ms"`
+}
+
+// barrierResults returns the timings of every barrier, in the order they
+// were added.
+func (server *Server) barrierResults() []BarrierResult {
+	set := &server.barriers
+	set.Lock()
+	defer set.Unlock()
+
+	var results []BarrierResult
+	for i, b := range set.order {
+		result := BarrierResult{
+			Name:     b.name,
+			Clients:  b.clients,
+			Released: !b.released.IsZero(),
+		}
+		if !b.first.IsZero() {
+			result.First = milliseconds(b.first.Sub(set.started))
+		}
+		if result.Released {
+			// groups may use different barriers, so the phase starts at the
+			// last earlier barrier released before this one
+			previous := set.started
+			for _, earlier := range set.order[:i] {
+				if earlier.released.After(previous) && !earlier.released.After(b.released) {
+					previous = earlier.released
+				}
+			}
+			result.All = milliseconds(b.released.Sub(set.started))
+			result.Phase = milliseconds(b.released.Sub(previous))
+		}
+		results = append(results, result)
+	}
+	return results
+}
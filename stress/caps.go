@@ -0,0 +1,134 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"log"
+	"strings"
+)
+
+// capNegotiation tracks a client's IRCv3 capability negotiation.
+type capNegotiation struct {
+	// available are the caps the server advertised, with their values.
+	available map[string]string
+	// enabled are the caps the server ACKed.
+	enabled map[string]bool
+	// active is true from sending CAP LS until sending CAP END.
+	active bool
+}
+
+// beginCapNegotiation starts capability negotiation if we want any caps.
+// Servers hold off on registering us until we send CAP END.
+func (client *Client) beginCapNegotiation() error {
+	if len(client.Caps) == 0 && !client.wantsSASL() {
+		return nil
+	}
+
+	client.Lock()
+	client.caps.available = make(map[string]string)
+	client.caps.enabled = make(map[string]bool)
+	client.caps.active = true
+	client.Unlock()
+
+	return client.Socket.WriteLine("CAP LS 302")
+}
+
+// HasCap returns true if the server enabled the given capability for us.
+func (client *Client) HasCap(name string) bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.caps.enabled[name]
+}
+
+// endCapNegotiation sends CAP END, if we haven't already.
+func (client *Client) endCapNegotiation() {
+	client.Lock()
+	active := client.caps.active
+	client.caps.active = false
+	client.Unlock()
+
+	if active {
+		client.Socket.WriteLine("CAP END")
+	}
+}
+
+// handleCap processes CAP messages from the server.
+func (client *Client) handleCap(server *Server, msg Message) {
+	if len(msg.Params) < 3 {
+		return
+	}
+
+	switch strings.ToUpper(msg.Params[1]) {
+	case "LS":
+		// multiline replies have a "*" param before the final cap list
+		client.Lock()
+		for _, token := range strings.Fields(msg.LastParam()) {
+			name := token
+			var value string
+			if index := strings.IndexByte(token, '='); index != -1 {
+				name = token[:index]
+				value = token[index+1:]
+			}
+			client.caps.available[name] = value
+		}
+		client.Unlock()
+
+		if len(msg.Params) == 3 {
+			client.requestCaps(server)
+		}
+	case "ACK":
+		client.Lock()
+		for _, name := range strings.Fields(msg.LastParam()) {
+			if strings.HasPrefix(name, "-") {
+				delete(client.caps.enabled, name[1:])
+			} else {
+				client.caps.enabled[name] = true
+			}
+		}
+		client.Unlock()
+
+		server.RecordCapResult(true)
+		if !client.wantsSASL() || !client.beginAuthentication(server) {
+			client.endCapNegotiation()
+		}
+	case "NAK":
+		log.Println(client.currentNick(), "caps rejected:", msg.LastParam())
+		server.RecordCapResult(false)
+		if client.wantsSASL() {
+			client.finishAuthentication(server, false, AFUnavailable)
+		}
+		client.endCapNegotiation()
+	}
+}
+
+// requestCaps requests the caps we want that the server advertised, or
+// ends negotiation if it doesn't support any of them.
+func (client *Client) requestCaps(server *Server) {
+	wanted := client.Caps
+	if client.wantsSASL() {
+		wanted = append([]string{"sasl"}, wanted...)
+	}
+
+	var request []string
+	client.Lock()
+	for _, name := range wanted {
+		if _, exists := client.caps.available[name]; exists {
+			request = append(request, name)
+		}
+	}
+	client.Unlock()
+
+	if len(request) < len(wanted) {
+		server.RecordCapsUnavailable()
+	}
+
+	if len(request) == 0 {
+		if client.wantsSASL() {
+			client.finishAuthentication(server, false, AFUnavailable)
+		}
+		client.endCapNegotiation()
+		return
+	}
+	client.Socket.WriteLine("CAP REQ :" + strings.Join(request, " "))
+}
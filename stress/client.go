@@ -5,15 +5,24 @@ package stress
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net"
-	"sync"
-
-	//"github.com/goshuirc/irc-go/ircmsg"
-
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errWaitTimeout      = errors.New("timed out")
+	errWaitAborted      = errors.New("aborted by server response")
+	errConnectionClosed = errors.New("connection closed")
+	errNoWaitMessage    = errors.New("no message to wait for")
+	errNotRegistered    = errors.New("server did not register us")
 )
 
 var skipVerifyConfig = &tls.Config{
@@ -24,28 +33,98 @@ var skipVerifyConfig = &tls.Config{
 type Client struct {
 	sync.Mutex
 
-	Nick   string
-	Socket *Socket
+	// ID identifies us in flood messages.
+	ID int
+	// Nick changes if it's in use while registering, so once we're
+	// connected it's only read with the lock held, see currentNick.
+	Nick     string
+	Username string
+	Realname string
+	Socket   *Socket
+
+	// NickSelector, if set, gives us new nicknames when ours is in use.
+	NickSelector *NickSelector
+	// NickFallback picks new nicknames when we don't have a NickSelector.
+	NickFallback NickFallback
+	// NickRetries is how many times we try new nicknames before giving up.
+	NickRetries int
+	// Caps are the IRCv3 capabilities we request during registration.
+	Caps []string
+	// SASL, if set, are the credentials we authenticate with during registration.
+	SASL *SASLCredentials
+	// Certificate, if set, is the TLS client certificate we connect with.
+	Certificate *tls.Certificate
+
+	// rand picks random nicknames, see NickFallback.
+	rand *rand.Rand
+
 	closed chan bool
 
 	pongEvent chan bool
+	readsDone chan struct{}
+
+	waiters     []*waiter
+	readsClosed bool
+
+	reg  registration
+	caps capNegotiation
+	sasl saslAuthentication
 
 	closeExpected bool
+	failed        bool
 	pingCounter   uint64
+	intendedSend  time.Time
+	quitSent      time.Time
+	floodSeq      uint64
 	lastPong      uint64
 	lastLine      string
 	totalLines    int
+
+	// joinsSent holds when we sent JOIN for each channel we're joining.
+	joinsSent map[string]time.Time
+	// floodTrackers follow the flood messages we get from each sender.
+	floodTrackers map[int]*seqTracker
+}
+
+// waiter is an armed WaitMessage, matched against incoming messages.
+type waiter struct {
+	wait   *WaitMessage
+	result chan error
 }
 
-func NewClient(id int) Client {
-	return Client{
+func NewClient(id int) *Client {
+	return &Client{
+		ID:          id,
 		Nick:        fmt.Sprintf("ircstress_%d", id),
+		Username:    "test",
+		Realname:    "I am a cool person!",
 		closed:      make(chan bool, 1),
 		pongEvent:   make(chan bool, 1),
+		readsDone:   make(chan struct{}),
 		pingCounter: 1,
+		rand:        rand.New(rand.NewSource(int64(id))),
+		reg: registration{
+			finished: make(chan struct{}),
+		},
 	}
 }
 
+// clone returns a new client with the same settings as this one, ready to
+// run against a server.
+func (client *Client) clone() *Client {
+	newClient := NewClient(client.ID)
+	newClient.Nick = client.Nick
+	newClient.Username = client.Username
+	newClient.Realname = client.Realname
+	newClient.NickSelector = client.NickSelector
+	newClient.NickFallback = client.NickFallback
+	newClient.NickRetries = client.NickRetries
+	newClient.Caps = client.Caps
+	newClient.SASL = client.SASL
+	newClient.Certificate = client.Certificate
+	return newClient
+}
+
 func (client *Client) SetCloseExpected(val bool) {
 	client.Lock()
 	defer client.Unlock()
@@ -58,6 +137,14 @@ func (client *Client) CloseExpected() bool {
 	return client.closeExpected
 }
 
+// currentNick returns the nickname we're using right now, which may have
+// changed since we started registering.
+func (client *Client) currentNick() string {
+	client.Lock()
+	defer client.Unlock()
+	return client.Nick
+}
+
 func (client *Client) recordPong(pong uint64) {
 	client.Lock()
 	defer client.Unlock()
@@ -72,27 +159,244 @@ func (client *Client) LastPong() uint64 {
 	return client.lastPong
 }
 
-func (client *Client) Ping() {
+// Ping sends a PING and waits for the server to reply to it.
+func (client *Client) Ping(server *Server) error {
 	client.Lock()
 	ping := client.pingCounter
 	client.pingCounter++
+	sent := client.intendedSend
 	client.Unlock()
 
-	client.Socket.Write(fmt.Sprintf("PING %d\r\n", ping))
+	err := client.Socket.Write(fmt.Sprintf("PING %d\r\n", ping))
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(server.Timeouts.withDefaults().Ping)
+	defer timer.Stop()
 	for {
-		<-client.pongEvent
-		if client.LastPong() >= ping {
+		select {
+		case <-client.pongEvent:
+			if client.LastPong() >= ping {
+				server.RecordLatency(LMPing, time.Since(sent))
+				return nil
+			}
+		case <-client.readsDone:
+			return errConnectionClosed
+		case <-timer.C:
+			return errWaitTimeout
+		}
+	}
+}
+
+// setIntendedSend sets when the event we're running was meant to happen,
+// which latencies are measured from.
+func (client *Client) setIntendedSend(intended time.Time) {
+	client.Lock()
+	defer client.Unlock()
+	client.intendedSend = intended
+}
+
+// Write sends the given data to the server, failing the client if the
+// write doesn't complete in time.
+func (client *Client) Write(server *Server, data string) error {
+	if len(data) > 5 && strings.EqualFold(data[:5], "JOIN ") {
+		client.trackJoin(data)
+	}
+
+	err := client.Socket.Write(data)
+	if isTimeout(err) {
+		client.fail(server, PhaseWrite, err)
+	}
+	return err
+}
+
+// trackJoin notes when we sent the given JOIN line, so we can time how long
+// the server takes to echo it back.
+func (client *Client) trackJoin(line string) {
+	msg, err := ParseMessage(line)
+	if err != nil || len(msg.Params) < 1 {
+		return
+	}
+
+	client.Lock()
+	defer client.Unlock()
+	if client.joinsSent == nil {
+		client.joinsSent = make(map[string]time.Time)
+	}
+	for _, channel := range strings.Split(msg.Params[0], ",") {
+		client.joinsSent[strings.ToLower(channel)] = client.intendedSend
+	}
+}
+
+// recordJoin records the JOIN round trip for the given channel.
+func (client *Client) recordJoin(server *Server, channel string) {
+	client.Lock()
+	sent, exists := client.joinsSent[strings.ToLower(channel)]
+	delete(client.joinsSent, strings.ToLower(channel))
+	client.Unlock()
+
+	if exists {
+		server.RecordLatency(LMJoin, time.Since(sent))
+	}
+}
+
+// fail marks the client as having failed during the given phase, and
+// closes its connection.
+func (client *Client) fail(server *Server, phase Phase, err error) {
+	client.Lock()
+	if client.failed {
+		client.Unlock()
+		return
+	}
+	client.failed = true
+	client.Unlock()
+
+	log.Println(client.currentNick(), "failed during", phase.String()+":", err.Error())
+	server.RecordFailure(phase)
+	if client.Socket != nil {
+		client.Socket.Close()
+	}
+}
+
+// Failed returns true if the client has failed.
+func (client *Client) Failed() bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.failed
+}
+
+// expect arms a waiter for the given message. Messages received after this
+// is called are matched against it. A nil message fails straight away.
+func (client *Client) expect(wm *WaitMessage) *waiter {
+	w := &waiter{
+		wait:   wm,
+		result: make(chan error, 1),
+	}
+	if wm == nil {
+		w.result <- errNoWaitMessage
+		return w
+	}
+
+	client.Lock()
+	defer client.Unlock()
+	if client.readsClosed {
+		w.result <- errConnectionClosed
+	} else {
+		client.waiters = append(client.waiters, w)
+	}
+	return w
+}
+
+// waitFor blocks until the given waiter matches, aborts or times out. The
+// given timeout is used if the waiter doesn't set its own.
+func (client *Client) waitFor(w *waiter, timeout time.Duration) error {
+	if w.wait != nil && w.wait.Timeout != 0 {
+		timeout = w.wait.Timeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-w.result:
+		return err
+	case <-timer.C:
+		client.removeWaiter(w)
+		return errWaitTimeout
+	}
+}
+
+func (client *Client) removeWaiter(w *waiter) {
+	client.Lock()
+	defer client.Unlock()
+	for i, other := range client.waiters {
+		if other == w {
+			client.waiters = append(client.waiters[:i], client.waiters[i+1:]...)
 			return
 		}
 	}
 }
 
+// dispatchWaiters resolves any waiters that the given message matches or aborts.
+func (client *Client) dispatchWaiters(msg Message) {
+	client.Lock()
+	defer client.Unlock()
+	remaining := client.waiters[:0]
+	for _, w := range client.waiters {
+		if w.wait.Matches(msg, client.Nick) {
+			w.result <- nil
+		} else if w.wait.Aborts(msg) {
+			w.result <- errWaitAborted
+		} else {
+			remaining = append(remaining, w)
+		}
+	}
+	client.waiters = remaining
+}
+
+// closeWaiters fails all current and future waiters once we can't read anymore.
+func (client *Client) closeWaiters() {
+	client.Lock()
+	defer client.Unlock()
+	client.readsClosed = true
+	for _, w := range client.waiters {
+		w.result <- errConnectionClosed
+	}
+	client.waiters = nil
+}
+
+// handleMessage processes the given message from the server, returning true
+// if it is the response to our deliberate QUIT.
+func (client *Client) handleMessage(server *Server, msg Message) bool {
+	if client.registering() {
+		client.handleRegistration(server, msg)
+	}
+
+	switch msg.Command {
+	case "ERROR":
+		if client.CloseExpected() {
+			client.Lock()
+			quitSent := client.quitSent
+			client.Unlock()
+			server.RecordLatency(LMQuit, time.Since(quitSent))
+			// clients the server refused to register didn't succeed, even
+			// if they quit cleanly
+			if client.registrationOK() {
+				server.RecordSuccess()
+			}
+			return true
+		}
+		log.Println(client.currentNick(), "unexpected quit:", msg.LastParam())
+	case "JOIN":
+		if strings.EqualFold(msg.Nick(), client.currentNick()) {
+			client.recordJoin(server, msg.Param(0))
+		}
+	case "PRIVMSG":
+		client.handleFlood(server, msg)
+	case "PONG":
+		// servers send either PONG <server> <token> or just PONG <token>
+		pongArg, err := strconv.ParseUint(msg.LastParam(), 10, 64)
+		if err == nil {
+			client.recordPong(pongArg)
+			// set the pong flag, wake if necessary, no-op if set
+			select {
+			case client.pongEvent <- true:
+			default:
+			}
+		}
+	}
+	return false
+}
+
 func (client *Client) readLoop(server *Server) {
 	quitRecvd := false
 
 	for {
 		line, err := client.Socket.Read()
-		if err != nil && !quitRecvd {
+		if err != nil && !quitRecvd && !client.Failed() {
+			if err != io.EOF {
+				server.RecordConnectionError(classifyConnectionError(err, false))
+			}
 			log.Println("Disconnected incorrectly 1:", err.Error())
 			log.Println("last line:", client.totalLines, ":", client.lastLine)
 			//TODO(dan): mark as closed badly
@@ -101,57 +405,67 @@ func (client *Client) readLoop(server *Server) {
 			break
 		}
 
-		if strings.HasPrefix(line, "ERROR Quit") {
-			if client.CloseExpected() {
-				server.RecordSuccess()
-				quitRecvd = true
-			} else {
-				log.Println(client.Nick, "unexpected quit")
-			}
+		msg, err := ParseMessage(line)
+		if err != nil {
+			log.Println(client.currentNick(), "could not parse line:", line)
 		} else {
-			pieces := strings.Split(line, " ")
-			if len(pieces) > 1 && pieces[len(pieces)-2] == "PONG" {
-				pongArg, err := strconv.ParseUint(pieces[len(pieces)-1], 10, 64)
-				if err == nil {
-					client.recordPong(pongArg)
-					// set the pong flag, wake if necessary, no-op if set
-					select {
-					case client.pongEvent <- true:
-					default:
-					}
-				}
+			client.dispatchWaiters(msg)
+			if client.handleMessage(server, msg) {
+				quitRecvd = true
 			}
 		}
 
 		client.lastLine = line
 		client.totalLines++
 	}
+	client.closeWaiters()
+	client.finishRegistration(server, false, RFClosed)
+	if client.CloseExpected() {
+		// clients dropped early can't tell what they missed
+		client.reportFloodGaps(server)
+	}
+	close(client.readsDone)
 	client.closed <- true
 }
 
 // Connect connects to the given server
 func (c *Client) Connect(server *Server) error {
+	timeouts := server.Timeouts.withDefaults()
+	dialer := net.Dialer{
+		Timeout: timeouts.Connect,
+	}
+
 	// connect
 	var conn net.Conn
 	var err error
 
 	addr := strings.TrimPrefix(server.Conn.Address, "unix:")
 
-	if server.Conn.IsTLS {
-		conn, err = tls.Dial("tcp", addr, skipVerifyConfig)
-	} else if strings.HasPrefix(addr, "/") {
-		conn, err = net.Dial("unix", addr)
+	started := time.Now()
+	if strings.HasPrefix(addr, "/") && !server.Conn.IsTLS {
+		conn, err = dialer.Dial("unix", addr)
 	} else {
-		conn, err = net.Dial("tcp", server.Conn.Address)
+		conn, err = dialer.Dial("tcp", addr)
 	}
-
 	if err != nil {
-		log.Fatal("Could not connect:", err.Error())
+		c.failConnect(server, PhaseConnect, err)
 		return err
 	}
+	server.RecordLatency(LMConnect, time.Since(started))
+
+	if server.Conn.IsTLS {
+		started = time.Now()
+		conn, err = c.handshake(conn, addr, timeouts.Handshake)
+		if err != nil {
+			c.failConnect(server, PhaseHandshake, err)
+			return err
+		}
+		server.RecordLatency(LMHandshake, time.Since(started))
+	}
 
 	// create socket
 	socket := NewSocket(conn)
+	socket.WriteTimeout = timeouts.Write
 	c.Socket = &socket
 
 	go c.readLoop(server)
@@ -159,19 +473,64 @@ func (c *Client) Connect(server *Server) error {
 	return nil
 }
 
-// Disconnect disconnects from the given server
-func (c *Client) Disconnect(server *Server) {
+// failConnect records the given connection error and fails the client.
+func (c *Client) failConnect(server *Server, phase Phase, err error) {
+	server.RecordConnectionError(classifyConnectionError(err, phase == PhaseHandshake))
+	c.fail(server, phase, err)
+}
+
+// handshake does the TLS handshake on the given connection, within the
+// given timeout.
+func (c *Client) handshake(rawConn net.Conn, addr string, timeout time.Duration) (net.Conn, error) {
+	config := skipVerifyConfig.Clone()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		config.ServerName = host
+	}
+	if c.Certificate != nil {
+		config.Certificates = []tls.Certificate{*c.Certificate}
+	}
+
+	conn := tls.Client(rawConn, config)
+	conn.SetDeadline(time.Now().Add(timeout))
+	err := conn.Handshake()
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// readyToDisconnect reports that we're ready to disconnect, returning false
+// if we're already disconnected.
+func (c *Client) readyToDisconnect(server *Server) bool {
 	// issue #4: report to other clients that we are ready to disconnect
 	server.ClientsReadyToDisconnect.Done()
-	if c.Socket.Closed {
+	if c.Socket == nil || c.Socket.Closed() {
 		log.Println("Disconnected early")
 		//TODO(dan): mark as closed badly
-	} else {
-		// wait for everyone to else to report the same
-		server.ClientsReadyToDisconnect.Wait()
-		log.Println(c.Nick, "disconnecting")
-		c.SetCloseExpected(true)
-		c.Socket.WriteLine("QUIT")
-		<-c.closed
+		return false
+	}
+	return true
+}
+
+// sendQuit sends our deliberate QUIT.
+func (c *Client) sendQuit() {
+	log.Println(c.currentNick(), "disconnecting")
+	c.SetCloseExpected(true)
+	c.Lock()
+	c.quitSent = time.Now()
+	c.Unlock()
+	c.Socket.WriteLine("QUIT")
+}
+
+// waitQuit waits for the server to close our connection after QUIT.
+func (c *Client) waitQuit(server *Server) {
+	timer := time.NewTimer(server.Timeouts.withDefaults().Quit)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+	case <-timer.C:
+		c.fail(server, PhaseQuit, errWaitTimeout)
 	}
 }
@@ -0,0 +1,76 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ConnectionError is the type of error a client's connection ran into.
+type ConnectionError int
+
+const (
+	// CERefused means the server refused the connection (ECONNREFUSED).
+	CERefused ConnectionError = iota
+	// CEReset means the server reset the connection (ECONNRESET).
+	CEReset
+	// CETimeout means the connection timed out.
+	CETimeout
+	// CETooManyFiles means we ran out of file descriptors (EMFILE/ENFILE).
+	CETooManyFiles
+	// CEAddrNotAvailable means we ran out of local ports (EADDRNOTAVAIL).
+	CEAddrNotAvailable
+	// CETLS means the TLS handshake failed.
+	CETLS
+	// CEOther is any other error.
+	CEOther
+
+	// NumConnectionErrors is the number of error types above.
+	NumConnectionErrors
+)
+
+var connectionErrorNames = [NumConnectionErrors]string{
+	CERefused:          "connection refused",
+	CEReset:            "connection reset",
+	CETimeout:          "timeout",
+	CETooManyFiles:     "too many open files",
+	CEAddrNotAvailable: "address not available",
+	CETLS:              "TLS handshake error",
+	CEOther:            "other",
+}
+
+// String returns a human-readable name for the error type.
+func (ce ConnectionError) String() string {
+	if ce < 0 || NumConnectionErrors <= ce {
+		return fmt.Sprintf("unknown (%d)", ce)
+	}
+	return connectionErrorNames[ce]
+}
+
+// classifyConnectionError returns the type of the given connection error.
+// Errors during the TLS handshake that aren't network errors are CETLS.
+func classifyConnectionError(err error, handshake bool) ConnectionError {
+	var recordErr tls.RecordHeaderError
+	var certErr x509.UnknownAuthorityError
+
+	switch {
+	case isTimeout(err):
+		return CETimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return CERefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return CEReset
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return CETooManyFiles
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return CEAddrNotAvailable
+	case errors.As(err, &recordErr), errors.As(err, &certErr), handshake:
+		return CETLS
+	}
+	return CEOther
+}
@@ -5,51 +5,58 @@ package stress
 
 import (
 	"fmt"
-	"log"
+	"strings"
+	"time"
 )
 
 // EventQueue represents a series of events.
 type EventQueue struct {
-	Client Client
+	Client *Client
 	Events []Event
-	id     int
+	// Pace, if set, runs the queue open-loop: ETLine and ETPing events are
+	// scheduled this far apart no matter how quickly the server responds,
+	// and latencies are measured from when they were meant to be sent.
+	Pace time.Duration
+	// Seed seeds the queue's random choices, like template variables. Runs
+	// with the same seed make the same choices.
+	Seed int64
+	id   int
+}
+
+// seed returns the seed for this queue's random choices, mixing in its ID
+// so each queue makes different ones.
+func (queue *EventQueue) seed() int64 {
+	return queue.Seed ^ int64(uint64(queue.id+1)*0x9E3779B97F4A7C15)
 }
 
 // NewEventQueue returns a new EventQueue
-func NewEventQueue(id int) EventQueue {
+func NewEventQueue(id int) *EventQueue {
 	events := EventQueue{
 		Events: make([]Event, 0),
 		Client: NewClient(id),
 		id:     id,
 	}
-	return events
+	return &events
 }
 
-// Run goes through our event list.
-func (queue EventQueue) Run(server *Server) {
-	client := &queue.Client
-	for _, event := range queue.Events {
-		switch event.Type {
-		case ETConnect:
-			fmt.Println("c", client.Nick)
-			err := client.Connect(server)
-			if err != nil {
-				log.Fatal("Could not connect...", err.Error())
+// Uses returns true if any of the queue's events are of the given type,
+// including those run by ETRepeat and ETChoice events.
+func (queue *EventQueue) Uses(et EventType) bool {
+	return eventsUse(queue.Events, et)
+}
+
+func eventsUse(events []Event, et EventType) bool {
+	for _, event := range events {
+		if event.Type == et || eventsUse(event.Events, et) {
+			return true
+		}
+		for _, choice := range event.Choices {
+			if eventsUse(choice.Events, et) {
+				return true
 			}
-		case ETDisconnect:
-			client.Disconnect(server)
-		case ETLine:
-			client.Socket.Write(event.Line)
-		case ETWait:
-			log.Println("ETWait events not yet implemented")
-		case ETPing:
-			client.Ping()
-		default:
-			panic(fmt.Sprintf("Unknown event type: %d", event.Type))
 		}
 	}
-	// send finished notice, used for syncing
-	server.ClientsFinished.Done()
+	return false
 }
 
 // EventType is the type of event it is.
@@ -66,19 +73,185 @@ const (
 	ETWait
 	// ETPing causes the client to send a ping, then wait for the specific response
 	ETPing
+	// ETRegister causes the client to send NICK and USER and start timing its registration.
+	ETRegister
+	// ETWaitRegistered makes the client wait until its registration has succeeded or failed.
+	ETWaitRegistered
+	// ETFlood causes the client to send Line to Target as a flood message,
+	// marked so that receivers can measure its delivery.
+	ETFlood
+	// ETSleep makes the client wait for Duration, give or take a random
+	// amount up to Jitter.
+	ETSleep
+	// ETRepeat runs Events Count times, or until Duration has passed. If both
+	// are set, it stops at whichever comes first.
+	ETRepeat
+	// ETChoice runs the Events of one of Choices, picked at random by weight.
+	ETChoice
+	// ETBarrier makes the client wait until every client has reached the
+	// barrier named Barrier, see Server.AddBarrier.
+	ETBarrier
 )
 
-// WaitMessage is a message that the client should wait for.
+// WaitMessage is a message that the client should wait for. Each of the
+// patterns may use '*' and '?' wildcards, and nil patterns match anything.
 type WaitMessage struct {
-	// Command is the IRC command to wait for.
+	// Command is the IRC command or numeric to wait for.
 	Command *string
-	// Params are the IRC message params to wait for.
+	// Source is the message source to wait for, e.g. "nick!*".
+	Source *string
+	// FromSelf requires the message to come from the client's current nickname.
+	FromSelf bool
+	// Target is the first param to wait for, e.g. the channel of a JOIN.
+	// Any params after it are ignored, such as those added by extended-join.
+	Target *string
+	// Params are the IRC message params to wait for, joined by spaces.
 	Params *string
+	// Abort lists commands or numerics that end the wait unsuccessfully.
+	Abort []string
+	// Timeout is how long to wait, the server's wait timeout if zero.
+	Timeout time.Duration
+}
+
+// Matches returns true if the given message is the one we're waiting for,
+// given that our current nickname is nick.
+func (wm *WaitMessage) Matches(msg Message, nick string) bool {
+	if wm.FromSelf && !strings.EqualFold(msg.Nick(), nick) {
+		return false
+	}
+	if wm.Command != nil && !matchMask(*wm.Command, msg.Command) {
+		return false
+	}
+	if wm.Source != nil && !matchMask(*wm.Source, msg.Source) {
+		return false
+	}
+	if wm.Target != nil && (len(msg.Params) < 1 || !matchMask(*wm.Target, msg.Params[0])) {
+		return false
+	}
+	if wm.Params != nil && !matchMask(*wm.Params, strings.Join(msg.Params, " ")) {
+		return false
+	}
+	return true
+}
+
+// Aborts returns true if the given message should end the wait unsuccessfully.
+func (wm *WaitMessage) Aborts(msg Message) bool {
+	for _, command := range wm.Abort {
+		if matchMask(command, msg.Command) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a human-readable version of the WaitMessage.
+func (wm *WaitMessage) String() string {
+	if wm == nil {
+		return "[nothing]"
+	}
+	pattern := func(p *string) string {
+		if p == nil {
+			return "*"
+		}
+		return *p
+	}
+	source := pattern(wm.Source)
+	if wm.FromSelf {
+		source = "<self>"
+	}
+	params := pattern(wm.Params)
+	if wm.Target != nil && wm.Params == nil {
+		params = *wm.Target + " *"
+	}
+	return fmt.Sprintf("[%s %s %s]", source, pattern(wm.Command), params)
 }
 
 // Event is an IRC event.
 type Event struct {
-	Type EventType
-	Line string
-	Wait *WaitMessage
+	Type   EventType
+	Line   string
+	Target string
+	Wait   *WaitMessage
+	// WaitReply makes an ETLine event wait for the server to respond to the
+	// line once it's been filled in, see WaitsAfter.
+	WaitReply bool
+	// Barrier is the name of an ETBarrier event's barrier.
+	Barrier string
+
+	Duration time.Duration
+	Jitter   time.Duration
+	Count    int
+	// Events and Choices are run by ETRepeat and ETChoice. They mustn't
+	// hold ETDisconnect or ETBarrier events, which only work in the queue's
+	// own events.
+	Events  []Event
+	Choices []Choice
+}
+
+// Choice is one of the options of an ETChoice event.
+type Choice struct {
+	Weight int
+	Events []Event
+}
+
+var (
+	// joinErrors are the replies that mean our channel join failed.
+	joinErrors = []string{"403", "405", "471", "473", "474", "475"}
+	// partErrors are the replies that mean our channel part failed.
+	partErrors = []string{"403", "442"}
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// WaitsAfter returns the events that wait for the server to respond to the
+// given line. Lines we can't wait after return nil.
+func WaitsAfter(line string) []Event {
+	msg, err := ParseMessage(line)
+	if err != nil {
+		return nil
+	}
+
+	var waits []Event
+	switch msg.Command {
+	case "JOIN":
+		if len(msg.Params) < 1 {
+			break
+		}
+		for _, channel := range strings.Split(msg.Params[0], ",") {
+			waits = append(waits, Event{
+				Type: ETWait,
+				Wait: &WaitMessage{
+					Command:  strPtr("JOIN"),
+					FromSelf: true,
+					Target:   strPtr(channel),
+					Abort:    joinErrors,
+				},
+			}, Event{
+				Type: ETWait,
+				Wait: &WaitMessage{
+					Command: strPtr("366"),
+					Params:  strPtr(fmt.Sprintf("* %s *", channel)),
+					Abort:   joinErrors,
+				},
+			})
+		}
+	case "PART":
+		if len(msg.Params) < 1 {
+			break
+		}
+		for _, channel := range strings.Split(msg.Params[0], ",") {
+			waits = append(waits, Event{
+				Type: ETWait,
+				Wait: &WaitMessage{
+					Command:  strPtr("PART"),
+					FromSelf: true,
+					Target:   strPtr(channel),
+					Abort:    partErrors,
+				},
+			})
+		}
+	}
+	return waits
 }
@@ -0,0 +1,195 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// floodMarker starts every flood message payload.
+	floodMarker = "ircstress"
+)
+
+// floodPayload is the information carried at the start of each flood message.
+type floodPayload struct {
+	Sender int
+	Seq    uint64
+	Sent   time.Time
+}
+
+// formatFloodPayload returns the message text for a flood message.
+func formatFloodPayload(payload floodPayload, text string) string {
+	return fmt.Sprintf("%s %d %d %d %s", floodMarker, payload.Sender, payload.Seq, payload.Sent.UnixNano(), text)
+}
+
+// parseFloodPayload parses the given message text, returning false if it
+// isn't a flood message.
+func parseFloodPayload(text string) (floodPayload, bool) {
+	var payload floodPayload
+	if !strings.HasPrefix(text, floodMarker+" ") {
+		return payload, false
+	}
+
+	fields := strings.SplitN(text[len(floodMarker)+1:], " ", 4)
+	if len(fields) < 3 {
+		return payload, false
+	}
+	sender, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return payload, false
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return payload, false
+	}
+	sent, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return payload, false
+	}
+
+	payload.Sender = sender
+	payload.Seq = seq
+	payload.Sent = time.Unix(0, sent)
+	return payload, true
+}
+
+// seqTracker follows the sequence numbers we receive from one sender, to
+// spot lost, reordered and duplicated messages.
+type seqTracker struct {
+	seen bool
+	// lowest and highest are the bounds of what we've received so far.
+	lowest  uint64
+	highest uint64
+	// missing are the sequence numbers between lowest and highest we haven't
+	// received.
+	missing map[uint64]bool
+}
+
+// seqResult is what a received sequence number tells us.
+type seqResult int
+
+const (
+	seqInOrder seqResult = iota
+	seqOutOfOrder
+	seqDuplicate
+)
+
+// record notes that we received the given sequence number.
+func (tracker *seqTracker) record(seq uint64) seqResult {
+	if tracker.missing == nil {
+		tracker.missing = make(map[uint64]bool)
+	}
+
+	// messages sent before we joined aren't missing, so start from the
+	// first one we see
+	if !tracker.seen {
+		tracker.seen = true
+		tracker.lowest = seq
+		tracker.highest = seq
+		return seqInOrder
+	}
+
+	switch {
+	case seq < tracker.lowest:
+		// an earlier message overtook this one, so the ones between were
+		// sent after we joined too
+		for missed := seq + 1; missed < tracker.lowest; missed++ {
+			tracker.missing[missed] = true
+		}
+		tracker.lowest = seq
+		return seqOutOfOrder
+	case tracker.highest < seq:
+		for missed := tracker.highest + 1; missed < seq; missed++ {
+			tracker.missing[missed] = true
+		}
+		tracker.highest = seq
+		return seqInOrder
+	case tracker.missing[seq]:
+		delete(tracker.missing, seq)
+		return seqOutOfOrder
+	default:
+		return seqDuplicate
+	}
+}
+
+// gaps returns how many of the given number of messages we never received
+// after the first one we saw.
+func (tracker *seqTracker) gaps(total uint64) uint64 {
+	gaps := uint64(len(tracker.missing))
+	if tracker.highest+1 < total {
+		gaps += total - (tracker.highest + 1)
+	}
+	return gaps
+}
+
+// Flood sends a flood message with the given text to the given target,
+// marked with our ID, the next sequence number and the send time.
+func (client *Client) Flood(server *Server, target string, text string) error {
+	client.Lock()
+	payload := floodPayload{
+		Sender: client.ID,
+		Seq:    client.floodSeq,
+		Sent:   client.intendedSend,
+	}
+	client.floodSeq++
+	client.Unlock()
+
+	if payload.Sent.IsZero() {
+		payload.Sent = time.Now()
+	}
+
+	err := client.Write(server, fmt.Sprintf("PRIVMSG %s :%s\r\n", target, formatFloodPayload(payload, text)))
+	if err == nil {
+		server.RecordFloodSent(client.ID)
+	}
+	return err
+}
+
+// handleFlood records the delivery of flood messages sent by other clients.
+// It's only called from readLoop, so the trackers don't need locking.
+func (client *Client) handleFlood(server *Server, msg Message) {
+	payload, isFlood := parseFloodPayload(msg.LastParam())
+	if !isFlood || payload.Sender == client.ID {
+		// our own messages come back with echo-message, they aren't deliveries
+		return
+	}
+
+	server.RecordLatency(LMDelivery, time.Since(payload.Sent))
+
+	if client.floodTrackers == nil {
+		client.floodTrackers = make(map[int]*seqTracker)
+	}
+	tracker := client.floodTrackers[payload.Sender]
+	if tracker == nil {
+		tracker = &seqTracker{}
+		client.floodTrackers[payload.Sender] = tracker
+	}
+
+	switch tracker.record(payload.Seq) {
+	case seqInOrder:
+		server.RecordFloodDelivered()
+	case seqOutOfOrder:
+		server.RecordFloodDelivered()
+		server.RecordFloodOutOfOrder()
+	case seqDuplicate:
+		server.RecordFloodDuplicate()
+	}
+}
+
+// reportFloodGaps records the messages missing from each sender we heard
+// from, once we've stopped receiving. Every client has finished flooding
+// by the time anyone deliberately disconnects.
+func (client *Client) reportFloodGaps(server *Server) {
+	var gaps uint64
+	for sender, tracker := range client.floodTrackers {
+		gaps += tracker.gaps(server.FloodSentBy(sender))
+	}
+	if gaps > 0 {
+		server.RecordFloodGaps(gaps)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeqTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		received []uint64
+		want     []seqResult
+		// total is how many messages the sender sent, and gaps how many
+		// of those we should have noticed missing
+		total uint64
+		gaps  uint64
+	}{
+		{
+			name:     "in order",
+			received: []uint64{0, 1, 2, 3},
+			want:     []seqResult{seqInOrder, seqInOrder, seqInOrder, seqInOrder},
+			total:    4,
+		},
+		{
+			name:     "joined late",
+			received: []uint64{5, 6, 7},
+			want:     []seqResult{seqInOrder, seqInOrder, seqInOrder},
+			total:    8,
+		},
+		{
+			name:     "lost in the middle",
+			received: []uint64{0, 1, 4, 5},
+			want:     []seqResult{seqInOrder, seqInOrder, seqInOrder, seqInOrder},
+			total:    6,
+			gaps:     2,
+		},
+		{
+			name:     "lost at the end",
+			received: []uint64{0, 1},
+			want:     []seqResult{seqInOrder, seqInOrder},
+			total:    5,
+			gaps:     3,
+		},
+		{
+			name:     "reordered",
+			received: []uint64{0, 2, 1, 3},
+			want:     []seqResult{seqInOrder, seqInOrder, seqOutOfOrder, seqInOrder},
+			total:    4,
+		},
+		{
+			name:     "duplicated",
+			received: []uint64{0, 1, 1, 2, 0},
+			want:     []seqResult{seqInOrder, seqInOrder, seqDuplicate, seqInOrder, seqDuplicate},
+			total:    3,
+		},
+		{
+			name:     "reordered and then duplicated",
+			received: []uint64{0, 2, 1, 1},
+			want:     []seqResult{seqInOrder, seqInOrder, seqOutOfOrder, seqDuplicate},
+			total:    3,
+		},
+		{
+			// a lower seq after the first one we saw was overtaken, it isn't
+			// a duplicate
+			name:     "first one seen overtook others",
+			received: []uint64{5, 3, 6, 4, 3},
+			want:     []seqResult{seqInOrder, seqOutOfOrder, seqInOrder, seqOutOfOrder, seqDuplicate},
+			total:    7,
+		},
+		{
+			name:     "overtaken with some lost",
+			received: []uint64{5, 2, 6},
+			want:     []seqResult{seqInOrder, seqOutOfOrder, seqInOrder},
+			total:    7,
+			gaps:     2,
+		},
+	}
+	for _, test := range tests {
+		var tracker seqTracker
+		for i, seq := range test.received {
+			if result := tracker.record(seq); result != test.want[i] {
+				t.Errorf("%s: record(%d) = %d, want %d", test.name, seq, result, test.want[i])
+			}
+		}
+		if gaps := tracker.gaps(test.total); gaps != test.gaps {
+			t.Errorf("%s: gaps(%d) = %d, want %d", test.name, test.total, gaps, test.gaps)
+		}
+	}
+}
+
+func TestFloodPayload(t *testing.T) {
+	payload := floodPayload{
+		Sender: 12,
+		Seq:    345,
+		Sent:   time.Unix(0, 1600000000123456789),
+	}
+	text := formatFloodPayload(payload, "some text with spaces")
+	parsed, isFlood := parseFloodPayload(text)
+	if !isFlood {
+		t.Fatalf("parseFloodPayload(%q) isn't a flood message", text)
+	}
+	if parsed.Sender != payload.Sender || parsed.Seq != payload.Seq || !parsed.Sent.Equal(payload.Sent) {
+		t.Errorf("parseFloodPayload(%q) = %+v, want %+v", text, parsed, payload)
+	}
+
+	for _, text := range []string{
+		"",
+		"hello there",
+		"ircstress",
+		"ircstress 1 2",
+		"ircstressed 1 2 3",
+		"ircstress one 2 3",
+		"ircstress 1 -2 3",
+		"ircstress 1 2 three",
+	} {
+		if _, isFlood := parseFloodPayload(text); isFlood {
+			t.Errorf("parseFloodPayload(%q) is a flood message, want not", text)
+		}
+	}
+}
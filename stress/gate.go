@@ -0,0 +1,229 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// LoadReport loads a report previously written with WriteJSON.
+func LoadReport(filename string) (*Report, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Threshold is the most that matching metrics may regress from the
+// baseline.
+type Threshold struct {
+	// Metric is the metric name to match, and may use '*' and '?'.
+	Metric string
+	// MaxRegression is the largest allowed change for the worse, as a
+	// percentage of the baseline value.
+	MaxRegression float64
+}
+
+// ParseThresholds parses a comma-separated list of thresholds, each like
+// "Registration p99=10%".
+func ParseThresholds(list string) ([]Threshold, error) {
+	var thresholds []Threshold
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		index := strings.LastIndexByte(entry, '=')
+		if index == -1 {
+			return nil, fmt.Errorf("threshold is not metric=percent: %s", entry)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(entry[index+1:]), "%"), 64)
+		if err != nil || percent < 0 {
+			return nil, fmt.Errorf("invalid percentage in threshold: %s", entry)
+		}
+		thresholds = append(thresholds, Threshold{
+			Metric:        strings.TrimSpace(entry[:index]),
+			MaxRegression: percent,
+		})
+	}
+	return thresholds, nil
+}
+
+// Violation is a limit that a server's results broke.
+type Violation struct {
+	Server string `json:"server"`
+	Metric string `json:"metric"`
+	// Value is the metric's value, if the results have it.
+	Value *float64   `json:"value,omitempty"`
+	Unit  MetricUnit `json:"unit"`
+	// Baseline is the metric's value in the baseline, if it was compared
+	// against one.
+	Baseline *float64 `json:"baseline,omitempty"`
+	// Limit describes the limit that was broken.
+	Limit string `json:"limit"`
+}
+
+// Gate decides whether results are good enough to pass.
+type Gate struct {
+	// Baseline is the report to compare against, if any. Servers are
+	// compared with the baseline server of the same name, using its means if
+	// it has several runs.
+	Baseline   *Report
+	Thresholds []Threshold
+	// MinSuccessRate is the lowest allowed success rate, between 0 and 1.
+	MinSuccessRate float64
+}
+
+// regression returns how much worse the value is than the baseline, as a
+// percentage. Improvements are negative.
+func regression(metric Metric, value, base float64) float64 {
+	change := value - base
+	if metric.HigherIsBetter() {
+		change = -change
+	}
+	if base == 0 {
+		if change > 0 {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	return 100 * change / math.Abs(base)
+}
+
+// Check returns the limits that the report's servers broke. The success
+// rate is checked for every run, and with several runs the means are
+// compared against the baseline. A server or metric that the thresholds
+// cover but that's missing from either side is a violation, except count
+// metrics, which are taken as zero on whichever side they're missing from.
+func (gate *Gate) Check(report *Report) []Violation {
+	var violations []Violation
+	for i := range report.Servers {
+		result := &report.Servers[i]
+		if result.SuccessRate < gate.MinSuccessRate {
+			successRate := result.SuccessRate
+			violations = append(violations, Violation{
+				Server: result.Name,
+				Metric: "Success Rate",
+				Value:  &successRate,
+				Unit:   MURatio,
+				Limit:  fmt.Sprintf("at least %.2f%%", 100*gate.MinSuccessRate),
+			})
+		}
+	}
+
+	if gate.Baseline == nil || len(gate.Thresholds) == 0 {
+		return violations
+	}
+	baseServers := make(map[string]bool)
+	for _, name := range gate.Baseline.serverNames() {
+		baseServers[name] = true
+	}
+	for _, name := range report.serverNames() {
+		if !baseServers[name] {
+			violations = append(violations, Violation{
+				Server: name,
+				Metric: "Results",
+				Limit:  "server is missing from baseline",
+			})
+			continue
+		}
+
+		metrics := make(map[string]Metric)
+		for _, metric := range report.serverMetrics(name) {
+			metrics[metric.Name] = metric
+		}
+		baseMetrics := gate.Baseline.serverMetrics(name)
+		inBaseline := make(map[string]bool)
+		for _, base := range baseMetrics {
+			inBaseline[base.Name] = true
+		}
+		for _, metric := range report.serverMetrics(name) {
+			if !inBaseline[metric.Name] && metric.Unit == MUCount {
+				// errors the baseline never saw weren't listed there
+				base := metric
+				base.Value = 0
+				baseMetrics = append(baseMetrics, base)
+			}
+		}
+
+		for _, base := range baseMetrics {
+			if !base.Ranked() {
+				continue
+			}
+			metric, exists := metrics[base.Name]
+			if !exists && base.Unit == MUCount {
+				// like unseen errors, which aren't listed when zero
+				metric, exists = base, true
+				metric.Value = 0
+			}
+			for _, threshold := range gate.Thresholds {
+				if !matchMask(threshold.Metric, base.Name) {
+					continue
+				}
+				baseValue := base.Value
+				violation := Violation{
+					Server:   name,
+					Metric:   base.Name,
+					Unit:     base.Unit,
+					Baseline: &baseValue,
+				}
+				if !exists {
+					violation.Limit = "missing, but in baseline"
+					violations = append(violations, violation)
+					break
+				}
+				if regression(metric, metric.Value, base.Value) > threshold.MaxRegression {
+					value := metric.Value
+					violation.Value = &value
+					violation.Limit = fmt.Sprintf("at most %g%% worse than baseline", threshold.MaxRegression)
+					violations = append(violations, violation)
+					break
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// WriteViolations writes a table of the given violations, if there are any.
+func WriteViolations(w io.Writer, violations []Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Failed Check", "Server", "Baseline", "Value", "Limit"})
+	for _, violation := range violations {
+		baseline := "-"
+		if violation.Baseline != nil {
+			baseline = Metric{Value: *violation.Baseline, Unit: violation.Unit}.Format()
+		}
+		value := "-"
+		if violation.Value != nil {
+			value = Metric{Value: *violation.Value, Unit: violation.Unit}.Format()
+		}
+		table.Append([]string{
+			violation.Metric,
+			violation.Server,
+			baseline,
+			value,
+			violation.Limit,
+		})
+	}
+	table.Render()
+}
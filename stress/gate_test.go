@@ -0,0 +1,185 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseThresholds(t *testing.T) {
+	tests := []struct {
+		list string
+		want []Threshold
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"Registration p99=10%", []Threshold{{Metric: "Registration p99", MaxRegression: 10}}},
+		{
+			" Registration p99 = 10% ,*Mean=2.5,Success Rate=0%",
+			[]Threshold{
+				{Metric: "Registration p99", MaxRegression: 10},
+				{Metric: "*Mean", MaxRegression: 2.5},
+				{Metric: "Success Rate", MaxRegression: 0},
+			},
+		},
+		{
+			// only the last '=' splits the metric from the percentage
+			"a=b=5%",
+			[]Threshold{{Metric: "a=b", MaxRegression: 5}},
+		},
+	}
+	for _, test := range tests {
+		thresholds, err := ParseThresholds(test.list)
+		if err != nil {
+			t.Errorf("ParseThresholds(%q) returned error: %s", test.list, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(thresholds, test.want) {
+			t.Errorf("ParseThresholds(%q) = %v, want %v", test.list, thresholds, test.want)
+		}
+	}
+
+	for _, list := range []string{"Registration p99", "Registration p99=", "a=ten%", "a=-5%", "a=5%,b"} {
+		if thresholds, err := ParseThresholds(list); err == nil {
+			t.Errorf("ParseThresholds(%q) = %v, want an error", list, thresholds)
+		}
+	}
+}
+
+func TestRegression(t *testing.T) {
+	lower := Metric{Name: "Registration p99", Unit: MUMilliseconds}
+	higher := Metric{Name: "Success Rate", Unit: MURatio}
+	tests := []struct {
+		metric Metric
+		value  float64
+		base   float64
+		want   float64
+	}{
+		{lower, 110, 100, 10},
+		{lower, 90, 100, -10},
+		{higher, 0.9, 1, 10},
+		{higher, 1, 0.5, -100},
+		{lower, 0, 0, 0},
+		{lower, 5, 0, math.Inf(1)},
+		{higher, 5, 0, 0},
+	}
+	for _, test := range tests {
+		if got := regression(test.metric, test.value, test.base); math.Abs(got-test.want) > 1e-9 && got != test.want {
+			t.Errorf("regression(%s, %g, %g) = %g, want %g", test.metric.Name, test.value, test.base, got, test.want)
+		}
+	}
+}
+
+// gateResult returns a server result for checking with a Gate.
+func gateResult(name string, registrationP99 float64, nickFailures uint64) ServerResult {
+	result := ServerResult{
+		Name:        name,
+		Clients:     10,
+		Succeeded:   10,
+		SuccessRate: 1,
+		Registered:  10,
+		Latencies: map[string]LatencyResult{
+			LMRegistration.String(): {Count: 10, P99: registrationP99},
+		},
+		RegistrationFailures: map[string]uint64{},
+	}
+	if nickFailures > 0 {
+		result.RegistrationFailures[RFNickInUse.String()] = nickFailures
+	}
+	return result
+}
+
+func TestGateCheck(t *testing.T) {
+	baseline := &Report{Servers: []ServerResult{gateResult("local", 10, 0)}}
+
+	tests := []struct {
+		name       string
+		thresholds string
+		results    []ServerResult
+		// violations are the metrics we expect to fail, in order
+		violations []string
+	}{
+		{
+			name:       "unchanged",
+			thresholds: "*=0%",
+			results:    []ServerResult{gateResult("local", 10, 0)},
+		},
+		{
+			name:       "within limit",
+			thresholds: "Registration p99=10%",
+			results:    []ServerResult{gateResult("local", 11, 0)},
+		},
+		{
+			name:       "over limit",
+			thresholds: "Registration p99=10%",
+			results:    []ServerResult{gateResult("local", 11.5, 0)},
+			violations: []string{"Registration p99"},
+		},
+		{
+			name:       "unmatched metric",
+			thresholds: "Registration Mean=10%",
+			results:    []ServerResult{gateResult("local", 20, 0)},
+		},
+		{
+			name:       "new failures",
+			thresholds: "Registration Failures*=0%",
+			results:    []ServerResult{gateResult("local", 10, 3)},
+			violations: []string{"Registration Failures (nick in use)"},
+		},
+		{
+			name:       "missing latency",
+			thresholds: "Registration p99=10%",
+			results: []ServerResult{{
+				Name:        "local",
+				Clients:     10,
+				SuccessRate: 1,
+			}},
+			violations: []string{"Registration p99"},
+		},
+		{
+			name:       "missing server",
+			thresholds: "*=10%",
+			results:    []ServerResult{gateResult("local", 10, 0), gateResult("other", 10, 0)},
+			violations: []string{"Results"},
+		},
+		{
+			name:       "unranked counts",
+			thresholds: "Total Clients=0%",
+			results: []ServerResult{func() ServerResult {
+				result := gateResult("local", 10, 0)
+				result.Clients = 5
+				return result
+			}()},
+		},
+	}
+	for _, test := range tests {
+		thresholds, err := ParseThresholds(test.thresholds)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		gate := Gate{
+			Baseline:   baseline,
+			Thresholds: thresholds,
+		}
+		var failed []string
+		for _, violation := range gate.Check(&Report{Servers: test.results}) {
+			failed = append(failed, violation.Metric)
+		}
+		if !reflect.DeepEqual(failed, test.violations) {
+			t.Errorf("%s: Check() failed %q, want %q", test.name, failed, test.violations)
+		}
+	}
+}
+
+func TestGateMinSuccessRate(t *testing.T) {
+	result := gateResult("local", 10, 0)
+	result.SuccessRate = 0.5
+	gate := Gate{MinSuccessRate: 0.99}
+	violations := gate.Check(&Report{Servers: []ServerResult{result}})
+	if len(violations) != 1 || violations[0].Metric != "Success Rate" || *violations[0].Value != 0.5 {
+		t.Errorf("Check() = %+v, want one Success Rate violation", violations)
+	}
+}
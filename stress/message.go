@@ -0,0 +1,189 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errEmptyMessage = errors.New("empty IRC message")
+)
+
+// Message is an IRC message received from the server.
+type Message struct {
+	// Tags are the IRCv3 message tags, with values unescaped. Tags without
+	// a value are present with an empty value.
+	Tags    map[string]string
+	Source  string
+	Command string
+	Params  []string
+}
+
+// ParseMessage parses the given IRC line into a Message.
+func ParseMessage(line string) (Message, error) {
+	var msg Message
+
+	line = strings.TrimRight(line, "\r\n")
+
+	// tags
+	if strings.HasPrefix(line, "@") {
+		index := strings.IndexByte(line, ' ')
+		if index == -1 {
+			return msg, errEmptyMessage
+		}
+		msg.Tags = parseTags(line[1:index])
+		line = strings.TrimLeft(line[index+1:], " ")
+	}
+
+	// source
+	if strings.HasPrefix(line, ":") {
+		index := strings.IndexByte(line, ' ')
+		if index == -1 {
+			return msg, errEmptyMessage
+		}
+		msg.Source = line[1:index]
+		line = strings.TrimLeft(line[index+1:], " ")
+	}
+
+	// command
+	index := strings.IndexByte(line, ' ')
+	if index == -1 {
+		msg.Command = strings.ToUpper(line)
+		line = ""
+	} else {
+		msg.Command = strings.ToUpper(line[:index])
+		line = line[index+1:]
+	}
+	if msg.Command == "" {
+		return msg, errEmptyMessage
+	}
+
+	// params
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			msg.Params = append(msg.Params, line[1:])
+			break
+		}
+		index = strings.IndexByte(line, ' ')
+		if index == -1 {
+			if line != "" {
+				msg.Params = append(msg.Params, line)
+			}
+			break
+		}
+		msg.Params = append(msg.Params, line[:index])
+		line = line[index+1:]
+	}
+
+	return msg, nil
+}
+
+// parseTags parses the given IRCv3 tag string, without the leading '@'.
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		index := strings.IndexByte(tag, '=')
+		if index == -1 {
+			tags[tag] = ""
+			continue
+		}
+		tags[tag[:index]] = unescapeTagValue(tag[index+1:])
+	}
+	return tags
+}
+
+// unescapeTagValue reverses the IRCv3 message-tags value escaping.
+func unescapeTagValue(value string) string {
+	if strings.IndexByte(value, '\\') == -1 {
+		return value
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		// a trailing lone backslash is dropped
+		i++
+		if i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			buf.WriteByte(';')
+		case 's':
+			buf.WriteByte(' ')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		default:
+			// covers \\ and unknown escapes, which just drop the backslash
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
+
+// Param returns the param at the given index, or an empty string if there
+// aren't enough params.
+func (msg *Message) Param(index int) string {
+	if 0 <= index && index < len(msg.Params) {
+		return msg.Params[index]
+	}
+	return ""
+}
+
+// LastParam returns the final param, or an empty string if there are none.
+func (msg *Message) LastParam() string {
+	return msg.Param(len(msg.Params) - 1)
+}
+
+// Nick returns the nickname part of the message source.
+func (msg *Message) Nick() string {
+	index := strings.IndexAny(msg.Source, "!@")
+	if index == -1 {
+		return msg.Source
+	}
+	return msg.Source[:index]
+}
+
+// matchMask returns true if the given string matches the given mask. Masks
+// may use '*' to match any run of characters and '?' to match exactly one.
+// Matching is case-insensitive, as is usual for IRC.
+func matchMask(mask, s string) bool {
+	mask = strings.ToLower(mask)
+	s = strings.ToLower(s)
+
+	// standard wildcard matching with single-star backtracking
+	var mi, si int
+	starMask, starStr := -1, 0
+	for si < len(s) {
+		if mi < len(mask) && (mask[mi] == '?' || mask[mi] == s[si]) {
+			mi++
+			si++
+		} else if mi < len(mask) && mask[mi] == '*' {
+			starMask = mi
+			starStr = si
+			mi++
+		} else if starMask != -1 {
+			mi = starMask + 1
+			starStr++
+			si = starStr
+		} else {
+			return false
+		}
+	}
+	for mi < len(mask) && mask[mi] == '*' {
+		mi++
+	}
+	return mi == len(mask)
+}
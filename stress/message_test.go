@@ -0,0 +1,133 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want Message
+	}{
+		{"PING", Message{Command: "PING"}},
+		{"ping :token\r\n", Message{Command: "PING", Params: []string{"token"}}},
+		{
+			":irc.example.com 001 cli0 :Welcome to the network",
+			Message{Source: "irc.example.com", Command: "001", Params: []string{"cli0", "Welcome to the network"}},
+		},
+		{
+			":cli0!test@host JOIN #test",
+			Message{Source: "cli0!test@host", Command: "JOIN", Params: []string{"#test"}},
+		},
+		{
+			// extended-join puts the account and realname after the channel
+			":cli0!test@host JOIN #test account :Real Name",
+			Message{Source: "cli0!test@host", Command: "JOIN", Params: []string{"#test", "account", "Real Name"}},
+		},
+		{
+			"PRIVMSG #test ::starts with a colon",
+			Message{Command: "PRIVMSG", Params: []string{"#test", ":starts with a colon"}},
+		},
+		{
+			"PRIVMSG #test :",
+			Message{Command: "PRIVMSG", Params: []string{"#test", ""}},
+		},
+		{
+			":src   MODE  #test  +o   cli0  ",
+			Message{Source: "src", Command: "MODE", Params: []string{"#test", "+o", "cli0"}},
+		},
+		{
+			"@time=2020-01-01T00:00:00.000Z;draft/bot :bot PRIVMSG #test :hi",
+			Message{
+				Tags:    map[string]string{"time": "2020-01-01T00:00:00.000Z", "draft/bot": ""},
+				Source:  "bot",
+				Command: "PRIVMSG",
+				Params:  []string{"#test", "hi"},
+			},
+		},
+		{
+			`@a=one\stwo\:three\\four\r\n;b=trailing\;c=unknown\x :src NOTICE * :x`,
+			Message{
+				Tags:    map[string]string{"a": "one two;three\\four\r\n", "b": "trailing", "c": "unknownx"},
+				Source:  "src",
+				Command: "NOTICE",
+				Params:  []string{"*", "x"},
+			},
+		},
+	}
+	for _, test := range tests {
+		msg, err := ParseMessage(test.line)
+		if err != nil {
+			t.Errorf("ParseMessage(%q) returned error: %s", test.line, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(msg, test.want) {
+			t.Errorf("ParseMessage(%q) = %#v, want %#v", test.line, msg, test.want)
+		}
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	for _, line := range []string{"", "\r\n", "@tags", ":source", "@tags :source", ":source "} {
+		if msg, err := ParseMessage(line); err == nil {
+			t.Errorf("ParseMessage(%q) = %#v, want an error", line, msg)
+		}
+	}
+}
+
+func TestMessageNick(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"cli0!test@host", "cli0"},
+		{"cli0@host", "cli0"},
+		{"irc.example.com", "irc.example.com"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		msg := Message{Source: test.source}
+		if nick := msg.Nick(); nick != test.want {
+			t.Errorf("Nick() of %q = %q, want %q", test.source, nick, test.want)
+		}
+	}
+}
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		mask string
+		s    string
+		want bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"JOIN", "join", true},
+		{"JOIN", "JOINS", false},
+		{"?", "a", true},
+		{"?", "", false},
+		{"??", "a", false},
+		{"4??", "433", true},
+		{"4??", "4330", false},
+		{"* #test *", "cli0 #test :End of /NAMES list.", true},
+		{"* #test *", "cli0 #tester :End of /NAMES list.", false},
+		{"*Mean", "Registration Mean", true},
+		{"*Mean", "Registration Mean Squares", false},
+		{"Registration p99", "registration P99", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXcYYb", false},
+		{"a*bc", "abcbc", true},
+		{"**a**", "xxaxx", true},
+		{"cli*!*@*", "cli5!test@localhost", true},
+	}
+	for _, test := range tests {
+		if got := matchMask(test.mask, test.s); got != test.want {
+			t.Errorf("matchMask(%q, %q) = %v, want %v", test.mask, test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricUnit is what a Metric's value measures.
+type MetricUnit string
+
+const (
+	// MUCount is a plain number of things.
+	MUCount MetricUnit = ""
+	// MURatio is a fraction between 0 and 1, shown as a percentage.
+	MURatio MetricUnit = "ratio"
+	// MUMilliseconds is a duration in milliseconds.
+	MUMilliseconds MetricUnit = "ms"
+)
+
+// Metric is a single named number from a ServerResult.
+type Metric struct {
+	Name  string
+	Value float64
+	Unit  MetricUnit
+
+	// order is where this metric is listed, the same for every server, so
+	// metrics that only some servers have still line up.
+	order int
+}
+
+// Format returns the metric's value in a readable form.
+func (metric Metric) Format() string {
+	switch metric.Unit {
+	case MURatio:
+		return fmt.Sprintf("%.2f%%", 100*metric.Value)
+	case MUMilliseconds:
+		return formatLatency(metric.Value)
+	default:
+		if metric.Value != math.Trunc(metric.Value) {
+			// means of counts over several runs
+			return strconv.FormatFloat(metric.Value, 'f', 2, 64)
+		}
+		return strconv.FormatFloat(metric.Value, 'f', -1, 64)
+	}
+}
+
+// higherIsBetter are the metrics where a rise is an improvement. Every
+// other metric is better when it's lower.
+var higherIsBetter = map[string]bool{
+	"Successful Clients":                true,
+	"Success Rate":                      true,
+	"Registered Clients":                true,
+	"Clients Recovered From Collisions": true,
+	"CAP REQs Acknowledged":             true,
+	"Authenticated Clients":             true,
+	"Flood Messages Delivered":          true,
+	"Delivery Ratio":                    true,
+}
+
+// unranked are the metrics that describe the test rather than how the server
+// did, so they're neither better nor worse when they change.
+var unranked = map[string]bool{
+	"Total Clients":           true,
+	"Flood Messages Sent":     true,
+	"Flood Messages Expected": true,
+}
+
+// HigherIsBetter returns true if a rise in this metric is an improvement.
+func (metric Metric) HigherIsBetter() bool {
+	// latency counts are how many times the server got that far
+	return higherIsBetter[metric.Name] || strings.HasSuffix(metric.Name, " Count")
+}
+
+// Ranked returns true if a change in this metric is better or worse, rather
+// than just a different test.
+func (metric Metric) Ranked() bool {
+	return !unranked[metric.Name]
+}
+
+// metricList builds up a list of metrics in their usual order.
+type metricList struct {
+	metrics []Metric
+	next    int
+}
+
+// add adds the given metric if include is true. Either way, it takes up a
+// place in the order.
+func (list *metricList) add(include bool, name string, value float64, unit MetricUnit) {
+	if include {
+		list.metrics = append(list.metrics, Metric{
+			Name:  name,
+			Value: value,
+			Unit:  unit,
+			order: list.next,
+		})
+	}
+	list.next++
+}
+
+// Metrics returns every metric in the result as a flat list, in the same
+// order as the tables. Optional metrics that weren't recorded are left out.
+func (result *ServerResult) Metrics() []Metric {
+	var list metricList
+
+	list.add(true, "Total Clients", float64(result.Clients), MUCount)
+	list.add(true, "Successful Clients", float64(result.Succeeded), MUCount)
+	list.add(true, "Success Rate", result.SuccessRate, MURatio)
+	list.add(true, "Registered Clients", float64(result.Registered), MUCount)
+	list.add(true, "Duration", result.Duration, MUMilliseconds)
+
+	nicks := result.Nicks
+	if nicks == nil {
+		nicks = &NickResults{}
+	}
+	list.add(result.Nicks != nil, "Nick Collisions", float64(nicks.Collisions), MUCount)
+	list.add(result.Nicks != nil, "Nick Retries", float64(nicks.Retries), MUCount)
+	list.add(result.Nicks != nil, "Clients Recovered From Collisions", float64(nicks.Recoveries), MUCount)
+
+	caps := result.Caps
+	if caps == nil {
+		caps = &CapResults{}
+	}
+	list.add(result.Caps != nil, "CAP REQs Acknowledged", float64(caps.Acked), MUCount)
+	list.add(result.Caps != nil, "CAP REQs Rejected", float64(caps.Rejected), MUCount)
+	list.add(result.Caps != nil, "Clients Missing Caps", float64(caps.Unavailable), MUCount)
+
+	var authenticated uint64
+	if result.Authenticated != nil {
+		authenticated = *result.Authenticated
+	}
+	list.add(result.Authenticated != nil, "Authenticated Clients", float64(authenticated), MUCount)
+	for af := AuthFailure(0); af < NumAuthFailures; af++ {
+		count := result.AuthFailures[af.String()]
+		list.add(count > 0, fmt.Sprintf("Authentication Failures (%s)", af.String()), float64(count), MUCount)
+	}
+
+	flood := result.Flood
+	if flood == nil {
+		flood = &FloodResults{}
+	}
+	list.add(result.Flood != nil, "Flood Messages Sent", float64(flood.Sent), MUCount)
+	list.add(result.Flood != nil, "Flood Messages Delivered", float64(flood.Delivered), MUCount)
+	list.add(result.Flood != nil, "Flood Messages Expected", float64(flood.Expected), MUCount)
+	list.add(result.Flood != nil && flood.Expected > 0, "Delivery Ratio", flood.DeliveryRatio, MURatio)
+
+	for ce := ConnectionError(0); ce < NumConnectionErrors; ce++ {
+		count := result.ConnectionErrors[ce.String()]
+		list.add(count > 0, fmt.Sprintf("Connection Errors (%s)", ce.String()), float64(count), MUCount)
+	}
+	for phase := Phase(0); phase < NumPhases; phase++ {
+		count := result.Failures[phase.String()]
+		list.add(count > 0, fmt.Sprintf("Failed Clients (%s)", phase.String()), float64(count), MUCount)
+	}
+	for rf := RegistrationFailure(0); rf < NumRegistrationFailures; rf++ {
+		count := result.RegistrationFailures[rf.String()]
+		list.add(count > 0, fmt.Sprintf("Registration Failures (%s)", rf.String()), float64(count), MUCount)
+	}
+
+	// correctness failures
+	list.add(result.Flood != nil, "Lost Messages", float64(flood.Lost), MUCount)
+	list.add(result.Flood != nil, "Sequence Gaps", float64(flood.Gaps), MUCount)
+	list.add(result.Flood != nil, "Out Of Order", float64(flood.OutOfOrder), MUCount)
+	list.add(result.Flood != nil, "Duplicates", float64(flood.Duplicates), MUCount)
+
+	for metric := LatencyMetric(0); metric < NumLatencyMetrics; metric++ {
+		latency, exists := result.Latencies[metric.String()]
+		name := metric.String()
+		list.add(exists, name+" Count", float64(latency.Count), MUCount)
+		list.add(exists, name+" Mean", latency.Mean, MUMilliseconds)
+		list.add(exists, name+" p50", latency.P50, MUMilliseconds)
+		list.add(exists, name+" p90", latency.P90, MUMilliseconds)
+		list.add(exists, name+" p99", latency.P99, MUMilliseconds)
+		list.add(exists, name+" p99.9", latency.P999, MUMilliseconds)
+		list.add(exists, name+" Max", latency.Max, MUMilliseconds)
+	}
+
+	for _, barrier := range result.Barriers {
+		list.add(barrier.Released, fmt.Sprintf("Barrier Reached (%s)", barrier.Name), barrier.All, MUMilliseconds)
+		list.add(barrier.Released, fmt.Sprintf("Phase Duration (%s)", barrier.Name), barrier.Phase, MUMilliseconds)
+	}
+
+	return list.metrics
+}
+
+// serverMetric is a metric from one of a report's servers.
+type serverMetric struct {
+	server int
+	Metric
+}
+
+// sortServerMetrics sorts the given metrics metric by metric, keeping each
+// server's values for a metric together in order.
+func sortServerMetrics(metrics []serverMetric) {
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].order < metrics[j].order
+	})
+}
+
+// metricsByName returns the metrics from every result, listed metric by
+// metric so that each server's value for a metric is next to the others.
+// server is the result's index in report.Servers.
+func (report *Report) metricsByName() []serverMetric {
+	var all []serverMetric
+	for i := range report.Servers {
+		for _, metric := range report.Servers[i].Metrics() {
+			all = append(all, serverMetric{
+				server: i,
+				Metric: metric,
+			})
+		}
+	}
+	sortServerMetrics(all)
+	return all
+}
@@ -4,27 +4,84 @@
 package stress
 
 import (
+	"fmt"
 	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+// NickFallback is how clients without a NickSelector pick a new nickname
+// when theirs is already in use.
+type NickFallback int
+
+const (
+	// NFUnderscore appends an underscore to the nickname.
+	NFUnderscore NickFallback = iota
+	// NFCounter appends the retry count to the original nickname.
+	NFCounter
+	// NFRandom appends random digits to the original nickname.
+	NFRandom
+)
+
+// NickFallbackFromString returns the NickFallback with the given name.
+func NickFallbackFromString(name string) (NickFallback, error) {
+	switch strings.ToLower(name) {
+	case "underscore":
+		return NFUnderscore, nil
+	case "counter":
+		return NFCounter, nil
+	case "random":
+		return NFRandom, nil
+	}
+	return NFUnderscore, fmt.Errorf("unknown nick fallback strategy: %s", name)
+}
+
+// Apply returns the nickname to try next, given the nickname we originally
+// tried and how many times we've retried. NFRandom picks digits with random.
+func (nf NickFallback) Apply(original string, current string, retries int, random *rand.Rand) string {
+	switch nf {
+	case NFCounter:
+		return original + strconv.Itoa(retries)
+	case NFRandom:
+		return fmt.Sprintf("%s%04d", original, random.Intn(10000))
+	default:
+		return current + "_"
+	}
+}
+
 // NickSelector takes given nicknames and provides you with nicknames to use.
+// It is safe to call GetNick from multiple goroutines.
 type NickSelector struct {
+	sync.Mutex
+
 	nicks           []string
 	selectedNick    int
 	nickLoopCount   int
 	RandomNickOrder bool
 	firstrundone    bool
+
+	// rand shuffles and munges nicks, see Seed.
+	rand *rand.Rand
 }
 
 // NewNickSelector returns an empty NickSelector with no nicks.
 func NewNickSelector() *NickSelector {
-	var ns NickSelector
+	ns := NickSelector{
+		rand: rand.New(rand.NewSource(1)),
+	}
 	return &ns
 }
 
+// Seed seeds the selector's random choices, so that selectors with the same
+// nicks and seed give out the same nicks in the same order.
+func (ns *NickSelector) Seed(seed int64) {
+	ns.Lock()
+	defer ns.Unlock()
+	ns.rand = rand.New(rand.NewSource(seed))
+}
+
 // NickSelectorFromList takes a list of nicks and returns a NickSelector.
 func NickSelectorFromList(nickList string) *NickSelector {
 	ns := NewNickSelector()
@@ -49,24 +106,28 @@ func NickSelectorFromList(nickList string) *NickSelector {
 		nickMap[nickBuffer] = true
 	}
 
-	// add nicks to our list
+	// add nicks to our list, sorted so that shuffling them can be repeated
 	for name := range nickMap {
 		ns.nicks = append(ns.nicks, name)
 	}
+	sort.Strings(ns.nicks)
 
 	return ns
 }
 
 // shuffle shuffles the given slice.
-func shuffle(a []string) {
+func shuffle(a []string, random *rand.Rand) {
 	for i := range a {
-		j := rand.Intn(i + 1)
+		j := random.Intn(i + 1)
 		a[i], a[j] = a[j], a[i]
 	}
 }
 
 // GetNick returns a nickname from the selector.
 func (ns *NickSelector) GetNick() string {
+	ns.Lock()
+	defer ns.Unlock()
+
 	// select the next nick
 	if ns.firstrundone {
 		ns.selectedNick++
@@ -79,7 +140,7 @@ func (ns *NickSelector) GetNick() string {
 	}
 
 	// add at least one nick
-	if len(ns.nicks) == 0 && len(ns.nicks) == 0 {
+	if len(ns.nicks) == 0 {
 		ns.nicks = []string{"user"}
 	}
 
@@ -96,18 +157,18 @@ func (ns *NickSelector) GetNick() string {
 
 	// randomise
 	if ns.selectedNick == 0 && ns.RandomNickOrder && 0 < len(ns.nicks) {
-		shuffle(ns.nicks)
+		shuffle(ns.nicks, ns.rand)
 	}
 
 	// get the actual nick
 	baseNick := ns.nicks[ns.selectedNick]
 
 	// munge the nickname as appropriate
-	if ns.nickLoopCount < 5 && 0.3 < rand.Float64() {
+	if ns.nickLoopCount < 5 && 0.3 < ns.rand.Float64() {
 		for i := 0; i < ns.nickLoopCount; i++ {
 			baseNick += "_"
 		}
-	} else if ns.nickLoopCount < 5 && 0.3 < rand.Float64() {
+	} else if ns.nickLoopCount < 5 && 0.3 < ns.rand.Float64() {
 		for i := 0; i < ns.nickLoopCount; i++ {
 			baseNick += "-"
 		}
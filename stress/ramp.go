@@ -0,0 +1,123 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RampProfile decides when each client starts, so we can control how
+// quickly connections are opened.
+type RampProfile interface {
+	// Offset returns how long after the start of the run the given client
+	// should start, out of the given total number of clients.
+	Offset(index int, total int) time.Duration
+	// String returns the profile in the format ParseRampProfile accepts.
+	String() string
+}
+
+// RampRate starts clients at a fixed number of connections per second.
+type RampRate struct {
+	PerSecond float64
+}
+
+// Offset implements RampProfile.
+func (ramp RampRate) Offset(index int, total int) time.Duration {
+	return time.Duration(float64(index) * float64(time.Second) / ramp.PerSecond)
+}
+
+func (ramp RampRate) String() string {
+	return fmt.Sprintf("rate:%s", strconv.FormatFloat(ramp.PerSecond, 'f', -1, 64))
+}
+
+// RampLinear spreads client starts evenly over the given duration.
+type RampLinear struct {
+	Duration time.Duration
+}
+
+// Offset implements RampProfile.
+func (ramp RampLinear) Offset(index int, total int) time.Duration {
+	return time.Duration(int64(ramp.Duration) * int64(index) / int64(total))
+}
+
+func (ramp RampLinear) String() string {
+	return fmt.Sprintf("linear:%s", ramp.Duration)
+}
+
+// RampStep starts clients in batches of the given size, one batch per interval.
+type RampStep struct {
+	Size     int
+	Interval time.Duration
+}
+
+// Offset implements RampProfile.
+func (ramp RampStep) Offset(index int, total int) time.Duration {
+	return time.Duration(index/ramp.Size) * ramp.Interval
+}
+
+func (ramp RampStep) String() string {
+	return fmt.Sprintf("step:%d/%s", ramp.Size, ramp.Interval)
+}
+
+// RampBurst starts every client at once, as soon as all queues are ready.
+type RampBurst struct{}
+
+// Offset implements RampProfile.
+func (ramp RampBurst) Offset(index int, total int) time.Duration {
+	return 0
+}
+
+func (ramp RampBurst) String() string {
+	return "burst"
+}
+
+// ParseRampProfile parses a ramp profile, one of:
+//
+//	rate:<conns-per-second>
+//	linear:<duration>
+//	step:<clients>/<interval>
+//	burst
+func ParseRampProfile(profile string) (RampProfile, error) {
+	name := profile
+	var arg string
+	if index := strings.IndexByte(profile, ':'); index != -1 {
+		name = profile[:index]
+		arg = profile[index+1:]
+	}
+
+	switch strings.ToLower(name) {
+	case "rate":
+		perSecond, err := strconv.ParseFloat(arg, 64)
+		if err != nil || perSecond <= 0 {
+			return nil, fmt.Errorf("invalid ramp rate: %s", profile)
+		}
+		return RampRate{PerSecond: perSecond}, nil
+	case "linear":
+		duration, err := time.ParseDuration(arg)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid ramp duration: %s", profile)
+		}
+		return RampLinear{Duration: duration}, nil
+	case "step":
+		pieces := strings.SplitN(arg, "/", 2)
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("ramp steps must look like step:<clients>/<interval>: %s", profile)
+		}
+		size, err := strconv.Atoi(pieces[0])
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid ramp step size: %s", profile)
+		}
+		interval, err := time.ParseDuration(pieces[1])
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid ramp step interval: %s", profile)
+		}
+		return RampStep{Size: size, Interval: interval}, nil
+	case "burst":
+		return RampBurst{}, nil
+	}
+	return nil, fmt.Errorf("unknown ramp profile: %s", profile)
+}
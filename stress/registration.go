@@ -0,0 +1,205 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// RegistrationFailure is the reason a client failed to register.
+type RegistrationFailure int
+
+const (
+	// RFNickInUse means the server replied with ERR_NICKNAMEINUSE (433).
+	RFNickInUse RegistrationFailure = iota
+	// RFErroneousNick means the server replied with ERR_ERRONEUSNICKNAME (432).
+	RFErroneousNick
+	// RFBanned means the server replied with ERR_YOUREBANNEDCREEP (465).
+	RFBanned
+	// RFOtherNumeric means the server replied with another registration error numeric.
+	RFOtherNumeric
+	// RFError means the server sent ERROR before we registered.
+	RFError
+	// RFClosed means the connection closed before we registered.
+	RFClosed
+	// RFTimeout means the server didn't register us in time.
+	RFTimeout
+	// RFQuit means we sent QUIT before the server registered us.
+	RFQuit
+
+	// NumRegistrationFailures is the number of failure reasons above.
+	NumRegistrationFailures
+)
+
+var registrationFailureNames = [NumRegistrationFailures]string{
+	RFNickInUse:     "nick in use",
+	RFErroneousNick: "erroneous nick",
+	RFBanned:        "banned",
+	RFOtherNumeric:  "other error numeric",
+	RFError:         "ERROR before registration",
+	RFClosed:        "connection closed",
+	RFTimeout:       "timeout",
+	RFQuit:          "quit before registration",
+}
+
+// String returns a human-readable name for the failure.
+func (rf RegistrationFailure) String() string {
+	if rf < 0 || NumRegistrationFailures <= rf {
+		return fmt.Sprintf("unknown (%d)", rf)
+	}
+	return registrationFailureNames[rf]
+}
+
+// registrationErrorNumerics maps the numerics that end registration
+// unsuccessfully to the failure they represent.
+var registrationErrorNumerics = map[string]RegistrationFailure{
+	"431": RFOtherNumeric, // ERR_NONICKNAMEGIVEN
+	"432": RFErroneousNick,
+	"433": RFNickInUse,
+	"436": RFOtherNumeric, // ERR_NICKCOLLISION
+	"437": RFOtherNumeric, // ERR_UNAVAILRESOURCE
+	"461": RFOtherNumeric, // ERR_NEEDMOREPARAMS
+	"465": RFBanned,
+}
+
+// registration tracks a client's registration handshake.
+type registration struct {
+	started      time.Time
+	timer        *time.Timer
+	finished     chan struct{}
+	done         bool
+	ok           bool
+	originalNick string
+	nickRetries  int
+}
+
+// Register sends NICK and USER and starts tracking how long it takes the
+// server to register us. The result is recorded on the server.
+func (client *Client) Register(server *Server) error {
+	client.Lock()
+	client.reg.started = time.Now()
+	client.reg.originalNick = client.Nick
+	client.reg.timer = time.AfterFunc(server.Timeouts.withDefaults().Registration, func() {
+		if client.finishRegistration(server, false, RFTimeout) {
+			client.fail(server, PhaseRegistration, errWaitTimeout)
+		}
+	})
+	nick := client.Nick
+	client.Unlock()
+
+	err := client.beginCapNegotiation()
+	if err == nil {
+		err = client.Socket.Write(fmt.Sprintf("NICK %s\r\n", nick))
+	}
+	if err == nil {
+		err = client.Socket.Write(fmt.Sprintf("USER %s 0 * :%s\r\n", client.Username, client.Realname))
+	}
+	return err
+}
+
+// WaitRegistered blocks until our registration has succeeded or failed, or
+// we've stopped reading from the server, returning true if we registered
+// successfully.
+func (client *Client) WaitRegistered() bool {
+	select {
+	case <-client.reg.finished:
+	case <-client.readsDone:
+	}
+	client.Lock()
+	defer client.Unlock()
+	return client.reg.ok
+}
+
+// registrationOK returns true if we registered successfully, or never tried
+// to register at all.
+func (client *Client) registrationOK() bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.reg.ok || client.reg.started.IsZero()
+}
+
+// registering returns true if we've started registration but not finished.
+func (client *Client) registering() bool {
+	client.Lock()
+	defer client.Unlock()
+	return !client.reg.started.IsZero() && !client.reg.done
+}
+
+// finishRegistration records the result of our registration, returning
+// false if it had already finished.
+func (client *Client) finishRegistration(server *Server, ok bool, failure RegistrationFailure) bool {
+	client.Lock()
+	if client.reg.done || client.reg.started.IsZero() {
+		client.Unlock()
+		return false
+	}
+	client.reg.done = true
+	client.reg.ok = ok
+	client.reg.timer.Stop()
+	elapsed := time.Since(client.reg.started)
+	recovered := client.reg.nickRetries > 0
+	close(client.reg.finished)
+	client.Unlock()
+
+	if ok {
+		server.RecordLatency(LMRegistration, elapsed)
+		if recovered {
+			server.RecordNickRecovery()
+		}
+	} else {
+		log.Println(client.currentNick(), "failed to register:", failure.String())
+		server.RecordRegistrationFailure(failure)
+	}
+	return true
+}
+
+// handleRegistration processes messages that affect our registration.
+func (client *Client) handleRegistration(server *Server, msg Message) {
+	if msg.Command == "CAP" {
+		client.handleCap(server, msg)
+	} else if client.wantsSASL() && (msg.Command == "AUTHENTICATE" || strings.HasPrefix(msg.Command, "90")) {
+		client.handleAuthentication(server, msg)
+	} else if msg.Command == "410" || (msg.Command == "421" && strings.EqualFold(msg.Param(1), "CAP")) {
+		// the server doesn't understand CAP, it'll register us without it
+		client.endCapNegotiation()
+	} else if msg.Command == "001" {
+		client.finishRegistration(server, true, 0)
+	} else if msg.Command == "ERROR" && client.CloseExpected() {
+		client.finishRegistration(server, false, RFQuit)
+	} else if msg.Command == "ERROR" {
+		client.finishRegistration(server, false, RFError)
+	} else if msg.Command == "433" {
+		server.RecordNickCollision()
+		if !client.retryNick(server) {
+			client.finishRegistration(server, false, RFNickInUse)
+		}
+	} else if failure, exists := registrationErrorNumerics[msg.Command]; exists {
+		client.finishRegistration(server, false, failure)
+	}
+}
+
+// retryNick picks a new nickname and sends it, returning false if we've
+// already retried as many times as we're allowed to.
+func (client *Client) retryNick(server *Server) bool {
+	client.Lock()
+	if client.NickRetries <= client.reg.nickRetries {
+		client.Unlock()
+		return false
+	}
+	client.reg.nickRetries++
+	if client.NickSelector != nil {
+		client.Nick = client.NickSelector.GetNick()
+	} else {
+		client.Nick = client.NickFallback.Apply(client.reg.originalNick, client.Nick, client.reg.nickRetries, client.rand)
+	}
+	nick := client.Nick
+	client.Unlock()
+
+	server.RecordNickRetry()
+	client.Socket.Write(fmt.Sprintf("NICK %s\r\n", nick))
+	return true
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// OutputFormat is how we write out results.
+type OutputFormat int
+
+const (
+	// OFTable writes human-readable tables.
+	OFTable OutputFormat = iota
+	// OFJSON writes a single JSON document.
+	OFJSON
+	// OFCSV writes one CSV record per server and metric.
+	OFCSV
+	// OFMarkdown writes a Markdown table with one row per server and metric.
+	OFMarkdown
+)
+
+// OutputFormatFromString returns the OutputFormat with the given name.
+func OutputFormatFromString(name string) (OutputFormat, error) {
+	switch strings.ToLower(name) {
+	case "table":
+		return OFTable, nil
+	case "json":
+		return OFJSON, nil
+	case "csv":
+		return OFCSV, nil
+	case "markdown", "md":
+		return OFMarkdown, nil
+	}
+	return OFTable, fmt.Errorf("unknown output format: %s", name)
+}
+
+// Report is the results of testing every server with one scenario.
+type Report struct {
+	Version string    `json:"version"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+	// Parameters are the options the scenario was run with.
+	Parameters map[string]interface{} `json:"parameters"`
+	// Runs is how many times each server was tested.
+	Runs int `json:"runs"`
+	// Seed seeded every random choice, so passing it to --seed repeats them.
+	Seed int64 `json:"seed"`
+	// Servers holds the results of every run against each server, in the
+	// order they were run.
+	Servers []ServerResult `json:"servers"`
+	// Statistics summarise each server's metrics across every run, when
+	// there's more than one.
+	Statistics []MetricStatistics `json:"statistics,omitempty"`
+	// Violations are the limits the results broke, see Gate.
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// NewReport returns a new Report for the given command.
+func NewReport(command string, parameters map[string]interface{}) *Report {
+	return &Report{
+		Version:    SemVer,
+		Command:    command,
+		Started:    time.Now(),
+		Parameters: parameters,
+		Runs:       1,
+	}
+}
+
+// Write writes the report in the given format.
+func (report *Report) Write(w io.Writer, format OutputFormat) error {
+	switch format {
+	case OFJSON:
+		return report.WriteJSON(w)
+	case OFCSV:
+		return report.WriteCSV(w)
+	case OFMarkdown:
+		report.WriteMarkdown(w)
+		return nil
+	default:
+		fmt.Fprintln(w, "Seed:", report.Seed)
+		for _, result := range report.Servers {
+			WriteTables(w, result)
+		}
+		report.WriteStatistics(w)
+		report.WriteComparison(w)
+		WriteViolations(w, report.Violations)
+		return nil
+	}
+}
+
+// formatRelative returns how the given value compares to the base value.
+func formatRelative(value, base float64) string {
+	if base == 0 {
+		if value == 0 {
+			return "same"
+		}
+		return "n/a"
+	}
+	change := 100 * (value - base) / base
+	if -0.005 < change && change < 0.005 {
+		return "same"
+	}
+	return fmt.Sprintf("%+.2f%%", change)
+}
+
+// WriteComparison writes a table comparing every server side by side, one
+// row per metric, with how each server compares to the first. With several
+// runs, it compares the means and says whether each difference is
+// significant. It writes nothing if there's only one server.
+func (report *Report) WriteComparison(w io.Writer) {
+	names := report.serverNames()
+	if len(names) < 2 {
+		return
+	}
+
+	header := []string{"Metric"}
+	header = append(header, names...)
+	for _, name := range names[1:] {
+		header = append(header, fmt.Sprintf("%s vs %s", name, names[0]))
+	}
+
+	// significance of each server's difference from the first, by metric
+	significance := make(map[string]bool)
+	for _, stats := range report.Statistics {
+		if stats.Significant != nil {
+			significance[stats.Server+"\x00"+stats.Metric] = *stats.Significant
+		}
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader(header)
+
+	var metrics []serverMetric
+	for i, name := range names {
+		for _, metric := range report.serverMetrics(name) {
+			metrics = append(metrics, serverMetric{
+				server: i,
+				Metric: metric,
+			})
+		}
+	}
+	sortServerMetrics(metrics)
+
+	for start := 0; start < len(metrics); {
+		// every server's value for this metric, nil where it's missing
+		values := make([]*Metric, len(names))
+		end := start
+		for ; end < len(metrics) && metrics[end].order == metrics[start].order; end++ {
+			values[metrics[end].server] = &metrics[end].Metric
+		}
+
+		row := []string{metrics[start].Name}
+		for _, value := range values {
+			if value == nil {
+				row = append(row, "-")
+			} else {
+				row = append(row, value.Format())
+			}
+		}
+		for i, value := range values[1:] {
+			if value == nil || values[0] == nil {
+				row = append(row, "-")
+				continue
+			}
+			relative := formatRelative(value.Value, values[0].Value)
+			if significant, exists := significance[names[i+1]+"\x00"+value.Name]; exists && relative != "same" {
+				if significant {
+					relative += " (significant)"
+				} else {
+					relative += " (noise)"
+				}
+			}
+			row = append(row, relative)
+		}
+		table.Append(row)
+
+		start = end
+	}
+	table.Render()
+}
+
+// WriteJSON writes the report as an indented JSON document.
+func (report *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteCSV writes the report as CSV, one record per server and metric.
+// Values are written as plain numbers, with ratios between 0 and 1, and
+// every record ends with the report's seed.
+func (report *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"server", "address", "tls", "run", "metric", "value", "unit", "seed"})
+	for _, metric := range report.metricsByName() {
+		server := report.Servers[metric.server]
+		writer.Write([]string{
+			server.Name,
+			server.Address,
+			strconv.FormatBool(server.TLS),
+			strconv.Itoa(server.Run),
+			metric.Name,
+			strconv.FormatFloat(metric.Value, 'f', -1, 64),
+			string(metric.Unit),
+			strconv.FormatInt(report.Seed, 10),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteMarkdown writes the report as a Markdown table, one row per server
+// and metric, ready to paste into an issue or pull request. With several
+// runs, each row summarises the metric across them.
+func (report *Report) WriteMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "Seed: `%d`\n\n", report.Seed)
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetBorders(tablewriter.Border{Left: true, Right: true})
+	table.SetCenterSeparator("|")
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+	if len(report.Statistics) > 0 {
+		table.SetHeader([]string{"Metric", "Server", "Mean", "StdDev", "Min", "Max", "95% CI"})
+		statistics := append([]MetricStatistics(nil), report.Statistics...)
+		sort.SliceStable(statistics, func(i, j int) bool {
+			return statistics[i].order < statistics[j].order
+		})
+		for _, stats := range statistics {
+			format := func(value float64) string {
+				return Metric{Value: value, Unit: stats.Unit}.Format()
+			}
+			table.Append([]string{
+				stats.Metric,
+				stats.Server,
+				format(stats.Mean),
+				format(stats.StdDev),
+				format(stats.Min),
+				format(stats.Max),
+				fmt.Sprintf("%s to %s", format(stats.CILow), format(stats.CIHigh)),
+			})
+		}
+	} else {
+		table.SetHeader([]string{"Metric", "Server", "Value"})
+		for _, metric := range report.metricsByName() {
+			table.Append([]string{metric.Name, report.Servers[metric.server].Name, metric.Format()})
+		}
+	}
+	table.Render()
+}
+
+// formatLatency returns the given latency in milliseconds, rounded to a
+// readable precision.
+func formatLatency(ms float64) string {
+	d := time.Duration(ms * float64(time.Millisecond))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
+
+// WriteTables writes the given server's results as human-readable tables.
+func WriteTables(w io.Writer, result ServerResult) {
+	data := [][]string{
+		[]string{"Total Clients", strconv.Itoa(result.Clients)},
+		[]string{"Successful Clients", strconv.FormatUint(result.Succeeded, 10)},
+		[]string{"Success Rate", fmt.Sprintf("%.2f%%", 100*result.SuccessRate)},
+		[]string{"Registered Clients", strconv.FormatUint(result.Registered, 10)},
+	}
+	if result.Nicks != nil {
+		data = append(data, []string{"Nick Collisions", strconv.FormatUint(result.Nicks.Collisions, 10)})
+		data = append(data, []string{"Nick Retries", strconv.FormatUint(result.Nicks.Retries, 10)})
+		data = append(data, []string{"Clients Recovered From Collisions", strconv.FormatUint(result.Nicks.Recoveries, 10)})
+	}
+	if result.Caps != nil {
+		data = append(data, []string{"CAP REQs Acknowledged", strconv.FormatUint(result.Caps.Acked, 10)})
+		data = append(data, []string{"CAP REQs Rejected", strconv.FormatUint(result.Caps.Rejected, 10)})
+		data = append(data, []string{"Clients Missing Caps", strconv.FormatUint(result.Caps.Unavailable, 10)})
+	}
+	if result.Authenticated != nil {
+		data = append(data, []string{"Authenticated Clients", strconv.FormatUint(*result.Authenticated, 10)})
+		for af := AuthFailure(0); af < NumAuthFailures; af++ {
+			if count := result.AuthFailures[af.String()]; count > 0 {
+				data = append(data, []string{fmt.Sprintf("Authentication Failures (%s)", af.String()), strconv.FormatUint(count, 10)})
+			}
+		}
+	}
+	if result.Flood != nil {
+		data = append(data, []string{"Flood Messages Sent", strconv.FormatUint(result.Flood.Sent, 10)})
+		data = append(data, []string{"Flood Messages Delivered", fmt.Sprintf("%d of %d expected", result.Flood.Delivered, result.Flood.Expected)})
+		if result.Flood.Expected > 0 {
+			data = append(data, []string{"Delivery Ratio", fmt.Sprintf("%.2f%%", 100*result.Flood.DeliveryRatio)})
+		}
+	}
+	for ce := ConnectionError(0); ce < NumConnectionErrors; ce++ {
+		if count := result.ConnectionErrors[ce.String()]; count > 0 {
+			data = append(data, []string{fmt.Sprintf("Connection Errors (%s)", ce.String()), strconv.FormatUint(count, 10)})
+		}
+	}
+	for phase := Phase(0); phase < NumPhases; phase++ {
+		if count := result.Failures[phase.String()]; count > 0 {
+			data = append(data, []string{fmt.Sprintf("Failed Clients (%s)", phase.String()), strconv.FormatUint(count, 10)})
+		}
+	}
+	for rf := RegistrationFailure(0); rf < NumRegistrationFailures; rf++ {
+		if count := result.RegistrationFailures[rf.String()]; count > 0 {
+			data = append(data, []string{fmt.Sprintf("Registration Failures (%s)", rf.String()), strconv.FormatUint(count, 10)})
+		}
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	for _, v := range data {
+		table.Append(v)
+	}
+	table.Render() // Send output
+
+	// correctness failures, kept apart from the performance numbers
+	if result.Flood != nil {
+		correctnessTable := tablewriter.NewWriter(w)
+		correctnessTable.SetAutoWrapText(false)
+		correctnessTable.SetHeader([]string{"Correctness", "Failures"})
+		correctnessTable.AppendBulk([][]string{
+			[]string{"Lost Messages", strconv.FormatUint(result.Flood.Lost, 10)},
+			[]string{"Sequence Gaps", strconv.FormatUint(result.Flood.Gaps, 10)},
+			[]string{"Out Of Order", strconv.FormatUint(result.Flood.OutOfOrder, 10)},
+			[]string{"Duplicates", strconv.FormatUint(result.Flood.Duplicates, 10)},
+		})
+		correctnessTable.Render()
+	}
+
+	// latencies
+	latencyTable := tablewriter.NewWriter(w)
+	latencyTable.SetAutoWrapText(false)
+	latencyTable.SetHeader([]string{"Latency", "Count", "Mean", "p50", "p90", "p99", "p99.9", "Max"})
+	for metric := LatencyMetric(0); metric < NumLatencyMetrics; metric++ {
+		latency, exists := result.Latencies[metric.String()]
+		if !exists {
+			continue
+		}
+		latencyTable.Append([]string{
+			metric.String(),
+			strconv.FormatUint(latency.Count, 10),
+			formatLatency(latency.Mean),
+			formatLatency(latency.P50),
+			formatLatency(latency.P90),
+			formatLatency(latency.P99),
+			formatLatency(latency.P999),
+			formatLatency(latency.Max),
+		})
+	}
+	if latencyTable.NumLines() > 0 {
+		latencyTable.Render()
+	}
+
+	// per-phase timings
+	if len(result.Barriers) > 0 {
+		barrierTable := tablewriter.NewWriter(w)
+		barrierTable.SetAutoWrapText(false)
+		barrierTable.SetHeader([]string{"Barrier", "Clients", "First", "All", "Phase"})
+		for _, barrier := range result.Barriers {
+			all, phase := "-", "-"
+			if barrier.Released {
+				all = formatLatency(barrier.All)
+				phase = formatLatency(barrier.Phase)
+			}
+			barrierTable.Append([]string{
+				barrier.Name,
+				strconv.Itoa(barrier.Clients),
+				formatLatency(barrier.First),
+				all,
+				phase,
+			})
+		}
+		barrierTable.Render()
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"time"
+)
+
+// LatencyResult summarises one latency histogram, in milliseconds.
+type LatencyResult struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	P999  float64 `json:"p99.9_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// milliseconds returns the given duration in fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// latencyResult summarises the given histogram.
+func latencyResult(h *Histogram) LatencyResult {
+	return LatencyResult{
+		Count: h.Count(),
+		Mean:  milliseconds(h.Mean()),
+		P50:   milliseconds(h.Percentile(50)),
+		P90:   milliseconds(h.Percentile(90)),
+		P99:   milliseconds(h.Percentile(99)),
+		P999:  milliseconds(h.Percentile(99.9)),
+		Max:   milliseconds(h.Max()),
+	}
+}
+
+// NickResults are how clients dealt with nick collisions.
+type NickResults struct {
+	Collisions uint64 `json:"collisions"`
+	Retries    uint64 `json:"retries"`
+	Recoveries uint64 `json:"recoveries"`
+}
+
+// CapResults are how the server responded to our CAP REQs.
+type CapResults struct {
+	Acked       uint64 `json:"acked"`
+	Rejected    uint64 `json:"rejected"`
+	Unavailable uint64 `json:"clients_missing_caps"`
+}
+
+// FloodResults are how many flood messages were delivered, and whether they
+// arrived correctly.
+type FloodResults struct {
+	Sent          uint64  `json:"sent"`
+	Delivered     uint64  `json:"delivered"`
+	Expected      uint64  `json:"expected"`
+	DeliveryRatio float64 `json:"delivery_ratio"`
+	Lost          uint64  `json:"lost"`
+	Gaps          uint64  `json:"sequence_gaps"`
+	OutOfOrder    uint64  `json:"out_of_order"`
+	Duplicates    uint64  `json:"duplicates"`
+}
+
+// ServerResult is a snapshot of everything we recorded while testing one
+// server. Error breakdowns only hold the reasons that actually happened.
+type ServerResult struct {
+	Name string `json:"name"`
+	// Run is which run against the server this is, starting from 1.
+	Run int `json:"run"`
+	// Address and TLS are how we connected to the server.
+	Address string `json:"address"`
+	TLS     bool   `json:"tls"`
+
+	// Duration is how long the whole test took, in milliseconds.
+	Duration float64 `json:"duration_ms"`
+
+	Clients     int     `json:"clients"`
+	Succeeded   uint64  `json:"succeeded"`
+	SuccessRate float64 `json:"success_rate"`
+	Registered  uint64  `json:"registered"`
+	// Authenticated is only set if we tried to authenticate.
+	Authenticated *uint64 `json:"authenticated,omitempty"`
+
+	Nicks *NickResults  `json:"nicks,omitempty"`
+	Caps  *CapResults   `json:"caps,omitempty"`
+	Flood *FloodResults `json:"flood,omitempty"`
+
+	ConnectionErrors     map[string]uint64 `json:"connection_errors"`
+	Failures             map[string]uint64 `json:"failures"`
+	RegistrationFailures map[string]uint64 `json:"registration_failures"`
+	AuthFailures         map[string]uint64 `json:"auth_failures"`
+
+	// Latencies are keyed by the LatencyMetric's name, and only hold
+	// metrics we recorded.
+	Latencies map[string]LatencyResult `json:"latencies"`
+
+	// Barriers are how long clients took to reach each barrier.
+	Barriers []BarrierResult `json:"barriers,omitempty"`
+}
+
+// ResultOptions says which optional parts of the test were run, and so
+// which results are worth including.
+type ResultOptions struct {
+	Clients int
+	Caps    bool
+	SASL    bool
+	Flood   bool
+}
+
+// Results returns a snapshot of the server's stats. It should be called
+// once every client has finished.
+func (server *Server) Results(options ResultOptions, duration time.Duration) ServerResult {
+	result := ServerResult{
+		Name:       server.Name,
+		Address:    server.Conn.Address,
+		TLS:        server.Conn.IsTLS,
+		Duration:   milliseconds(duration),
+		Clients:    options.Clients,
+		Succeeded:  server.Succeeded(),
+		Registered: server.Registered(),
+
+		ConnectionErrors:     make(map[string]uint64),
+		Failures:             make(map[string]uint64),
+		RegistrationFailures: make(map[string]uint64),
+		AuthFailures:         make(map[string]uint64),
+		Latencies:            make(map[string]LatencyResult),
+	}
+	if options.Clients > 0 {
+		result.SuccessRate = float64(result.Succeeded) / float64(options.Clients)
+	}
+
+	if options.SASL {
+		authenticated := server.Authenticated()
+		result.Authenticated = &authenticated
+	}
+	collisions, retries, recoveries := server.NickCollisions()
+	if collisions > 0 {
+		result.Nicks = &NickResults{
+			Collisions: collisions,
+			Retries:    retries,
+			Recoveries: recoveries,
+		}
+	}
+	if options.Caps {
+		acked, naked, unavailable := server.CapResults()
+		result.Caps = &CapResults{
+			Acked:       acked,
+			Rejected:    naked,
+			Unavailable: unavailable,
+		}
+	}
+	if options.Flood {
+		sent, delivered, expected := server.FloodDeliveries()
+		gaps, outOfOrder, duplicates := server.FloodCorrectness()
+		flood := FloodResults{
+			Sent:       sent,
+			Delivered:  delivered,
+			Expected:   expected,
+			Gaps:       gaps,
+			OutOfOrder: outOfOrder,
+			Duplicates: duplicates,
+		}
+		if delivered < expected {
+			flood.Lost = expected - delivered
+		}
+		if expected > 0 {
+			flood.DeliveryRatio = float64(delivered) / float64(expected)
+		}
+		result.Flood = &flood
+	}
+
+	for ce := ConnectionError(0); ce < NumConnectionErrors; ce++ {
+		if count := server.ConnectionErrors(ce); count > 0 {
+			result.ConnectionErrors[ce.String()] = count
+		}
+	}
+	for phase := Phase(0); phase < NumPhases; phase++ {
+		if count := server.Failures(phase); count > 0 {
+			result.Failures[phase.String()] = count
+		}
+	}
+	for rf := RegistrationFailure(0); rf < NumRegistrationFailures; rf++ {
+		if count := server.RegistrationFailures(rf); count > 0 {
+			result.RegistrationFailures[rf.String()] = count
+		}
+	}
+	for af := AuthFailure(0); af < NumAuthFailures; af++ {
+		if count := server.AuthFailures(af); count > 0 {
+			result.AuthFailures[af.String()] = count
+		}
+	}
+	for metric := LatencyMetric(0); metric < NumLatencyMetrics; metric++ {
+		if h := server.Latency(metric); h.Count() > 0 {
+			result.Latencies[metric.String()] = latencyResult(h)
+		}
+	}
+	result.Barriers = server.barrierResults()
+
+	return result
+}
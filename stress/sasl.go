@@ -0,0 +1,220 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// saslChunkSize is the most base64 data we can send in one AUTHENTICATE line.
+	saslChunkSize = 400
+)
+
+// SASLMechanism is a SASL mechanism we can authenticate with.
+type SASLMechanism int
+
+const (
+	// SASLPlain authenticates with an account name and password.
+	SASLPlain SASLMechanism = iota
+	// SASLExternal authenticates with our TLS client certificate.
+	SASLExternal
+)
+
+// String returns the IRC name of the mechanism.
+func (mech SASLMechanism) String() string {
+	if mech == SASLExternal {
+		return "EXTERNAL"
+	}
+	return "PLAIN"
+}
+
+// SASLMechanismFromString returns the SASLMechanism with the given name.
+func SASLMechanismFromString(name string) (SASLMechanism, error) {
+	switch strings.ToUpper(name) {
+	case "PLAIN":
+		return SASLPlain, nil
+	case "EXTERNAL":
+		return SASLExternal, nil
+	}
+	return SASLPlain, fmt.Errorf("unknown SASL mechanism: %s", name)
+}
+
+// SASLCredentials are what a client authenticates with.
+type SASLCredentials struct {
+	Mechanism SASLMechanism
+	Account   string
+	Password  string
+}
+
+// SASLCredentialsFromPattern returns PLAIN credentials from a pattern like
+// "user%d:pass%d", where each %d is replaced with the given client id.
+func SASLCredentialsFromPattern(pattern string, id int) (SASLCredentials, error) {
+	expanded := strings.Replace(pattern, "%d", strconv.Itoa(id), -1)
+	return parseSASLCredentials(expanded)
+}
+
+// LoadSASLCredentials loads PLAIN credentials from the given file, one
+// "account:password" pair per line.
+func LoadSASLCredentials(filename string) ([]SASLCredentials, error) {
+	fileBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []SASLCredentials
+	for _, line := range strings.Split(string(fileBytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		cred, err := parseSASLCredentials(line)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("no SASL credentials in %s", filename)
+	}
+	return creds, nil
+}
+
+func parseSASLCredentials(pair string) (SASLCredentials, error) {
+	index := strings.IndexByte(pair, ':')
+	if index < 1 {
+		return SASLCredentials{}, fmt.Errorf("SASL credentials must look like account:password, got: %s", pair)
+	}
+	return SASLCredentials{
+		Mechanism: SASLPlain,
+		Account:   pair[:index],
+		Password:  pair[index+1:],
+	}, nil
+}
+
+// AuthFailure is the reason a client failed to authenticate.
+type AuthFailure int
+
+const (
+	// AFFailed means the server replied with ERR_SASLFAIL (904).
+	AFFailed AuthFailure = iota
+	// AFMechanismUnavailable means the server doesn't support our mechanism (908).
+	AFMechanismUnavailable
+	// AFUnavailable means the server didn't offer the sasl capability.
+	AFUnavailable
+	// AFOtherNumeric means the server replied with another SASL error numeric.
+	AFOtherNumeric
+
+	// NumAuthFailures is the number of failure reasons above.
+	NumAuthFailures
+)
+
+var authFailureNames = [NumAuthFailures]string{
+	AFFailed:               "failed",
+	AFMechanismUnavailable: "mechanism unavailable",
+	AFUnavailable:          "sasl unavailable",
+	AFOtherNumeric:         "other error numeric",
+}
+
+// String returns a human-readable name for the failure.
+func (af AuthFailure) String() string {
+	if af < 0 || NumAuthFailures <= af {
+		return fmt.Sprintf("unknown (%d)", af)
+	}
+	return authFailureNames[af]
+}
+
+// saslAuthentication tracks a client's SASL exchange.
+type saslAuthentication struct {
+	started time.Time
+	done    bool
+}
+
+// wantsSASL returns true if we should authenticate during registration.
+func (client *Client) wantsSASL() bool {
+	return client.SASL != nil
+}
+
+// beginAuthentication starts our SASL exchange once the sasl cap is ACKed,
+// returning false if we can't authenticate.
+func (client *Client) beginAuthentication(server *Server) bool {
+	if !client.HasCap("sasl") {
+		client.finishAuthentication(server, false, AFUnavailable)
+		return false
+	}
+
+	client.Lock()
+	client.sasl.started = time.Now()
+	client.Unlock()
+
+	client.Socket.WriteLine("AUTHENTICATE " + client.SASL.Mechanism.String())
+	return true
+}
+
+// sendAuthenticateResponse sends our response to the server's challenge.
+func (client *Client) sendAuthenticateResponse() {
+	if client.SASL.Mechanism == SASLExternal {
+		client.Socket.WriteLine("AUTHENTICATE +")
+		return
+	}
+
+	response := fmt.Sprintf("%s\x00%s\x00%s", client.SASL.Account, client.SASL.Account, client.SASL.Password)
+	encoded := base64.StdEncoding.EncodeToString([]byte(response))
+	for len(encoded) >= saslChunkSize {
+		client.Socket.WriteLine("AUTHENTICATE " + encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+	// an empty final chunk is sent as "+"
+	if encoded == "" {
+		encoded = "+"
+	}
+	client.Socket.WriteLine("AUTHENTICATE " + encoded)
+}
+
+// finishAuthentication records the result of our SASL exchange and lets
+// registration continue.
+func (client *Client) finishAuthentication(server *Server, ok bool, failure AuthFailure) {
+	client.Lock()
+	if client.sasl.done {
+		client.Unlock()
+		return
+	}
+	client.sasl.done = true
+	elapsed := time.Since(client.sasl.started)
+	client.Unlock()
+
+	if ok {
+		server.RecordLatency(LMAuthentication, elapsed)
+	} else {
+		log.Println(client.currentNick(), "failed to authenticate:", failure.String())
+		server.RecordAuthFailure(failure)
+	}
+	client.endCapNegotiation()
+}
+
+// handleAuthentication processes AUTHENTICATE and SASL numerics.
+func (client *Client) handleAuthentication(server *Server, msg Message) {
+	switch msg.Command {
+	case "AUTHENTICATE":
+		if msg.Param(0) == "+" {
+			client.sendAuthenticateResponse()
+		}
+	case "900":
+		// RPL_LOGGEDIN, 903 follows
+	case "903":
+		client.finishAuthentication(server, true, 0)
+	case "904":
+		client.finishAuthentication(server, false, AFFailed)
+	case "908":
+		client.finishAuthentication(server, false, AFMechanismUnavailable)
+	case "902", "905", "906", "907":
+		client.finishAuthentication(server, false, AFOtherNumeric)
+	}
+}
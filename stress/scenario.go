@@ -0,0 +1,442 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Scenario is a load pattern loaded from a YAML or JSON file. It declares
+// groups of clients, each running the same script.
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Channel is the default target of flood steps.
+	Channel string `yaml:"channel"`
+	// Ramp is how quickly to start clients, see ParseRampProfile. --ramp
+	// overrides it.
+	Ramp   string          `yaml:"ramp"`
+	Groups []ScenarioGroup `yaml:"groups"`
+
+	// dir is where the scenario was loaded from, other files it names are
+	// relative to this.
+	dir string
+}
+
+// ScenarioGroup is a number of clients that all run the same script.
+type ScenarioGroup struct {
+	Name  string `yaml:"name"`
+	Count int    `yaml:"count"`
+	// Nick is the nickname pattern, where %d is replaced with the client's
+	// ID. It's ignored if NicksFile is set.
+	Nick        string         `yaml:"nick"`
+	NicksFile   string         `yaml:"nicks_file"`
+	RandomNicks bool           `yaml:"random_nicks"`
+	Caps        []string       `yaml:"caps"`
+	Script      []ScenarioStep `yaml:"script"`
+}
+
+// ScenarioStep is a single step in a group's script. Simple steps can be
+// written as just their name, e.g. "connect", everything else is a map with
+// exactly one of the steps set.
+type ScenarioStep struct {
+	Connect        bool `yaml:"connect"`
+	Register       bool `yaml:"register"`
+	WaitRegistered bool `yaml:"wait_registered"`
+	Ping           bool `yaml:"ping"`
+	Disconnect     bool `yaml:"disconnect"`
+
+	// Send is an IRC line to send, and WaitReply waits for the server to
+	// respond to it, where we know how to. Lines may use template variables,
+	// see template.go, where {chan} is the scenario's channel.
+	Send      string `yaml:"send"`
+	WaitReply bool   `yaml:"wait_reply"`
+
+	Wait  *ScenarioWait  `yaml:"wait"`
+	Flood *ScenarioFlood `yaml:"flood"`
+
+	// Barrier waits until every client that uses the named barrier has
+	// reached it, see ETBarrier.
+	Barrier string `yaml:"barrier"`
+
+	// Sleep, Repeat and Choice control the flow of the script. The steps
+	// they run can't disconnect or use barriers.
+	Sleep  *ScenarioSleep   `yaml:"sleep"`
+	Repeat *ScenarioRepeat  `yaml:"repeat"`
+	Choice []ScenarioChoice `yaml:"choice"`
+}
+
+// ScenarioWait waits for a message from the server, see WaitMessage.
+type ScenarioWait struct {
+	Command  string        `yaml:"command"`
+	Source   string        `yaml:"source"`
+	FromSelf bool          `yaml:"from_self"`
+	Params   string        `yaml:"params"`
+	Abort    []string      `yaml:"abort"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// ScenarioFlood sends flood messages, see Client.Flood.
+type ScenarioFlood struct {
+	// Target defaults to the scenario's channel.
+	Target string `yaml:"target"`
+	// Text may use template variables, see template.go.
+	Text string `yaml:"text"`
+	// Count is how many messages to send, 1 if not given.
+	Count int `yaml:"count"`
+}
+
+// ScenarioSleep pauses the script, see ETSleep.
+type ScenarioSleep struct {
+	Duration time.Duration `yaml:"duration"`
+	// Jitter is the most the sleep is randomly shortened or lengthened by.
+	Jitter time.Duration `yaml:"jitter"`
+}
+
+// UnmarshalYAML lets fixed sleeps be given as just their duration.
+func (sleep *ScenarioSleep) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var duration time.Duration
+	if err := unmarshal(&duration); err == nil {
+		sleep.Duration = duration
+		return nil
+	}
+
+	type plainSleep ScenarioSleep
+	return unmarshal((*plainSleep)(sleep))
+}
+
+// ScenarioRepeat runs its script Count times, or for Duration. If both are
+// given, it stops at whichever comes first.
+type ScenarioRepeat struct {
+	Count    int            `yaml:"count"`
+	Duration time.Duration  `yaml:"duration"`
+	Script   []ScenarioStep `yaml:"script"`
+}
+
+// ScenarioChoice is one of the scripts a choice step picks between.
+type ScenarioChoice struct {
+	// Weight is how likely this script is to be picked, relative to the
+	// others. It defaults to 1.
+	Weight int            `yaml:"weight"`
+	Script []ScenarioStep `yaml:"script"`
+}
+
+// UnmarshalYAML lets simple steps be given as just their name.
+func (step *ScenarioStep) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		switch name {
+		case "connect":
+			step.Connect = true
+		case "register":
+			step.Register = true
+		case "wait_registered":
+			step.WaitRegistered = true
+		case "ping":
+			step.Ping = true
+		case "disconnect":
+			step.Disconnect = true
+		default:
+			return fmt.Errorf("unknown scenario step: %s", name)
+		}
+		return nil
+	}
+
+	// without this type, unmarshal would call us again
+	type plainStep ScenarioStep
+	return unmarshal((*plainStep)(step))
+}
+
+// LoadScenario loads the given scenario file. JSON files are loaded the
+// same way as YAML.
+func LoadScenario(filename string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var scenario Scenario
+	err = yaml.UnmarshalStrict(data, &scenario)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse scenario %s: %s", filename, err.Error())
+	}
+	scenario.dir = filepath.Dir(filename)
+	if scenario.Name == "" {
+		scenario.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	return &scenario, scenario.validate()
+}
+
+// validate checks that the scenario makes sense.
+func (scenario *Scenario) validate() error {
+	if len(scenario.Groups) == 0 {
+		return errors.New("scenario has no client groups")
+	}
+	if scenario.Ramp != "" {
+		if _, err := ParseRampProfile(scenario.Ramp); err != nil {
+			return err
+		}
+	}
+	for i, group := range scenario.Groups {
+		if group.Name == "" {
+			scenario.Groups[i].Name = fmt.Sprintf("group%d", i+1)
+			group.Name = scenario.Groups[i].Name
+		}
+		if group.Count < 1 {
+			return fmt.Errorf("group %s needs a count of at least 1", group.Name)
+		}
+		if len(group.Script) == 0 {
+			return fmt.Errorf("group %s has no script", group.Name)
+		}
+		if err := validateScript(scenario, group.Script, false); err != nil {
+			return fmt.Errorf("group %s, %s", group.Name, err.Error())
+		}
+		// nothing can be sent before connecting, and connecting twice would
+		// start a second read loop
+		if !group.Script[0].Connect {
+			return fmt.Errorf("group %s must connect as the first step of its script", group.Name)
+		}
+		barriers := make(map[string]bool)
+		var registered bool
+		for j, step := range group.Script {
+			if step.Connect && j != 0 {
+				return fmt.Errorf("group %s can only connect once", group.Name)
+			}
+			if step.Register && registered {
+				return fmt.Errorf("group %s can only register once", group.Name)
+			}
+			registered = registered || step.Register
+			// registration is only tracked once it's started with register
+			if step.WaitRegistered && !registered {
+				return fmt.Errorf("group %s needs to register before wait_registered", group.Name)
+			}
+			// everything after disconnecting would wait forever
+			if step.Disconnect && j != len(group.Script)-1 {
+				return fmt.Errorf("group %s can only disconnect as the last step of its script", group.Name)
+			}
+			if step.Barrier != "" && barriers[step.Barrier] {
+				return fmt.Errorf("group %s uses barrier %s more than once", group.Name, step.Barrier)
+			}
+			barriers[step.Barrier] = true
+		}
+	}
+	return nil
+}
+
+// validateScript checks each step in the given script. Nested scripts are
+// those run by repeat and choice steps.
+func validateScript(scenario *Scenario, script []ScenarioStep, nested bool) error {
+	for i, step := range script {
+		if err := step.validate(scenario, nested); err != nil {
+			return fmt.Errorf("step %d: %s", i+1, err.Error())
+		}
+	}
+	return nil
+}
+
+// validate checks that exactly one thing is set in the step.
+func (step *ScenarioStep) validate(scenario *Scenario, nested bool) error {
+	var set int
+	for _, isSet := range []bool{step.Connect, step.Register, step.WaitRegistered, step.Ping, step.Disconnect, step.Send != "", step.Wait != nil, step.Flood != nil, step.Barrier != "", step.Sleep != nil, step.Repeat != nil, step.Choice != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("each step must do exactly one thing")
+	}
+	if step.WaitReply && step.Send == "" {
+		return errors.New("wait_reply only works with send")
+	}
+	if (step.Connect || step.Register || step.WaitRegistered || step.Disconnect) && nested {
+		return errors.New("connect, register, wait_registered and disconnect can't be used inside repeat or choice")
+	}
+	if step.Barrier != "" && nested {
+		return errors.New("barrier can't be used inside repeat or choice")
+	}
+	if step.Flood != nil && step.Flood.Target == "" && scenario.Channel == "" {
+		return errors.New("flood needs a target, or the scenario needs a channel")
+	}
+	if step.Sleep != nil && (step.Sleep.Duration < 0 || step.Sleep.Jitter < 0) {
+		return errors.New("sleep can't be negative")
+	}
+	if step.Repeat != nil {
+		if step.Repeat.Count < 1 && step.Repeat.Duration <= 0 {
+			return errors.New("repeat needs a count or a duration")
+		}
+		if len(step.Repeat.Script) == 0 {
+			return errors.New("repeat has no script")
+		}
+		if err := validateScript(scenario, step.Repeat.Script, true); err != nil {
+			return fmt.Errorf("repeat, %s", err.Error())
+		}
+	}
+	if step.Choice != nil && len(step.Choice) == 0 {
+		return errors.New("choice has nothing to choose from")
+	}
+	for i := range step.Choice {
+		choice := &step.Choice[i]
+		if choice.Weight == 0 {
+			choice.Weight = 1
+		}
+		if choice.Weight < 0 {
+			return fmt.Errorf("choice %d: weight can't be negative", i+1)
+		}
+		if len(choice.Script) == 0 {
+			return fmt.Errorf("choice %d has no script", i+1)
+		}
+		if err := validateScript(scenario, choice.Script, true); err != nil {
+			return fmt.Errorf("choice %d, %s", i+1, err.Error())
+		}
+	}
+	if err := ValidateTemplate(step.Send); err != nil {
+		return err
+	}
+	if step.Flood != nil {
+		return ValidateTemplate(step.Flood.Text)
+	}
+	return nil
+}
+
+// events returns the events this step runs.
+func (step *ScenarioStep) events(scenario *Scenario) []Event {
+	switch {
+	case step.Connect:
+		return []Event{{Type: ETConnect}}
+	case step.Register:
+		return []Event{{Type: ETRegister}}
+	case step.WaitRegistered:
+		return []Event{{Type: ETWaitRegistered}}
+	case step.Ping:
+		return []Event{{Type: ETPing}}
+	case step.Disconnect:
+		return []Event{{Type: ETDisconnect}}
+	case step.Send != "":
+		line := strings.TrimRight(step.Send, "\r\n") + "\r\n"
+		return []Event{{Type: ETLine, Line: line, Target: scenario.Channel, WaitReply: step.WaitReply}}
+	case step.Wait != nil:
+		wm := WaitMessage{
+			FromSelf: step.Wait.FromSelf,
+			Abort:    step.Wait.Abort,
+			Timeout:  step.Wait.Timeout,
+		}
+		if step.Wait.Command != "" {
+			wm.Command = strPtr(step.Wait.Command)
+		}
+		if step.Wait.Source != "" {
+			wm.Source = strPtr(step.Wait.Source)
+		}
+		if step.Wait.Params != "" {
+			wm.Params = strPtr(step.Wait.Params)
+		}
+		return []Event{{Type: ETWait, Wait: &wm}}
+	case step.Flood != nil:
+		target := step.Flood.Target
+		if target == "" {
+			target = scenario.Channel
+		}
+		count := step.Flood.Count
+		if count < 1 {
+			count = 1
+		}
+		events := make([]Event, count)
+		for i := range events {
+			events[i] = Event{
+				Type:   ETFlood,
+				Target: target,
+				Line:   step.Flood.Text,
+			}
+		}
+		return events
+	case step.Barrier != "":
+		return []Event{{Type: ETBarrier, Barrier: step.Barrier}}
+	case step.Sleep != nil:
+		return []Event{{Type: ETSleep, Duration: step.Sleep.Duration, Jitter: step.Sleep.Jitter}}
+	case step.Repeat != nil:
+		return []Event{{
+			Type:     ETRepeat,
+			Count:    step.Repeat.Count,
+			Duration: step.Repeat.Duration,
+			Events:   scriptEvents(scenario, step.Repeat.Script),
+		}}
+	case step.Choice != nil:
+		event := Event{Type: ETChoice}
+		for _, choice := range step.Choice {
+			event.Choices = append(event.Choices, Choice{
+				Weight: choice.Weight,
+				Events: scriptEvents(scenario, choice.Script),
+			})
+		}
+		return []Event{event}
+	}
+	return nil
+}
+
+// scriptEvents returns the events the given script runs.
+func scriptEvents(scenario *Scenario, script []ScenarioStep) []Event {
+	var events []Event
+	for _, step := range script {
+		events = append(events, step.events(scenario)...)
+	}
+	return events
+}
+
+// EventQueues returns an event queue for each of the scenario's clients,
+// numbered from zero. Every script ends by disconnecting, even if it doesn't
+// say so. Each group's nicks are picked using the given seed, and groups
+// with neither a nicks_file nor a nick pattern take nicks from the given
+// selector, if there is one.
+func (scenario *Scenario) EventQueues(seed int64, nicks *NickSelector) ([]*EventQueue, error) {
+	random := rand.New(rand.NewSource(seed))
+	var queues []*EventQueue
+	for _, group := range scenario.Groups {
+		groupSeed := random.Int63()
+		var ns *NickSelector
+		if group.NicksFile != "" {
+			filename := group.NicksFile
+			if !filepath.IsAbs(filename) {
+				filename = filepath.Join(scenario.dir, filename)
+			}
+			listBytes, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("could not load nicks for group %s: %s", group.Name, err.Error())
+			}
+			ns = NickSelectorFromList(string(listBytes))
+			ns.RandomNickOrder = group.RandomNicks
+			ns.Seed(groupSeed)
+		} else if group.Nick == "" {
+			ns = nicks
+		}
+		nickPattern := group.Nick
+		if nickPattern == "" {
+			nickPattern = "cli%d"
+		}
+
+		script := scriptEvents(scenario, group.Script)
+		if script[len(script)-1].Type != ETDisconnect {
+			script = append(script, Event{Type: ETDisconnect})
+		}
+
+		for i := 0; i < group.Count; i++ {
+			id := len(queues)
+			queue := NewEventQueue(id)
+			if ns != nil {
+				queue.Client.Nick = ns.GetNick()
+				queue.Client.NickSelector = ns
+			} else {
+				queue.Client.Nick = strings.Replace(nickPattern, "%d", fmt.Sprint(id), -1)
+			}
+			queue.Client.Caps = group.Caps
+			queue.Events = append(queue.Events, script...)
+			queues = append(queues, queue)
+		}
+	}
+	return queues, nil
+}
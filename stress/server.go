@@ -6,6 +6,7 @@ package stress
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // ServerConnectionDetails holds the details used to connect to the server.
@@ -17,13 +18,47 @@ type ServerConnectionDetails struct {
 // Server represents a server we are stress-testing.
 type Server struct {
 	// stats
-	succeeded uint64 // align to 64-bit boundary
+	succeeded            uint64 // align to 64-bit boundary
+	registrationFailures [NumRegistrationFailures]uint64
+	nickCollisions       uint64
+	nickRetries          uint64
+	nickRecoveries       uint64
+	capsAcked            uint64
+	capsNaked            uint64
+	capsUnavailable      uint64
+	authFailures         [NumAuthFailures]uint64
+	failures             [NumPhases]uint64
+	connectionErrors     [NumConnectionErrors]uint64
+	floodSent            uint64
+	floodDelivered       uint64
+	floodGaps            uint64
+	floodOutOfOrder      uint64
+	floodDuplicates      uint64
+	latencies            [NumLatencyMetrics]Histogram
 
 	ClientsReadyToDisconnect sync.WaitGroup
 	ClientsFinished          sync.WaitGroup
 
-	Name string
-	Conn ServerConnectionDetails
+	// barriers are the named sync points in clients' events, see AddBarrier.
+	barriers barrierSet
+
+	// floodSentBy holds a *uint64 count of flood messages sent by each client ID.
+	floodSentBy sync.Map
+
+	Name     string
+	Conn     ServerConnectionDetails
+	Timeouts Timeouts
+}
+
+// NewRun returns a copy of the server's details with fresh stats and wait
+// groups, ready to run the tests again. Anything still running from an
+// earlier run, like a read loop waiting to time out, keeps the old server.
+func (server *Server) NewRun() *Server {
+	return &Server{
+		Name:     server.Name,
+		Conn:     server.Conn,
+		Timeouts: server.Timeouts,
+	}
 }
 
 func (server *Server) RecordSuccess() {
@@ -33,3 +68,164 @@ func (server *Server) RecordSuccess() {
 func (server *Server) Succeeded() uint64 {
 	return atomic.LoadUint64(&server.succeeded)
 }
+
+// RecordRegistrationFailure records a client failing to register.
+func (server *Server) RecordRegistrationFailure(failure RegistrationFailure) {
+	atomic.AddUint64(&server.registrationFailures[failure], 1)
+}
+
+// RegistrationFailures returns how many clients failed to register for the given reason.
+func (server *Server) RegistrationFailures(failure RegistrationFailure) uint64 {
+	return atomic.LoadUint64(&server.registrationFailures[failure])
+}
+
+// RecordNickCollision records the server telling a client its nick is in use.
+func (server *Server) RecordNickCollision() {
+	atomic.AddUint64(&server.nickCollisions, 1)
+}
+
+// RecordNickRetry records a client trying a new nick after a collision.
+func (server *Server) RecordNickRetry() {
+	atomic.AddUint64(&server.nickRetries, 1)
+}
+
+// RecordNickRecovery records a client registering after a nick collision.
+func (server *Server) RecordNickRecovery() {
+	atomic.AddUint64(&server.nickRecoveries, 1)
+}
+
+// NickCollisions returns the number of nick collisions, retries and
+// clients that registered after colliding.
+func (server *Server) NickCollisions() (collisions, retries, recoveries uint64) {
+	return atomic.LoadUint64(&server.nickCollisions), atomic.LoadUint64(&server.nickRetries), atomic.LoadUint64(&server.nickRecoveries)
+}
+
+// RecordCapResult records the server ACKing or NAKing a client's CAP REQ.
+func (server *Server) RecordCapResult(acked bool) {
+	if acked {
+		atomic.AddUint64(&server.capsAcked, 1)
+	} else {
+		atomic.AddUint64(&server.capsNaked, 1)
+	}
+}
+
+// RecordCapsUnavailable records the server not advertising some of the caps
+// a client wanted.
+func (server *Server) RecordCapsUnavailable() {
+	atomic.AddUint64(&server.capsUnavailable, 1)
+}
+
+// CapResults returns how many CAP REQs were ACKed and NAKed, and how many
+// clients found some of their caps unavailable.
+func (server *Server) CapResults() (acked, naked, unavailable uint64) {
+	return atomic.LoadUint64(&server.capsAcked), atomic.LoadUint64(&server.capsNaked), atomic.LoadUint64(&server.capsUnavailable)
+}
+
+// RecordAuthFailure records a client failing to authenticate.
+func (server *Server) RecordAuthFailure(failure AuthFailure) {
+	atomic.AddUint64(&server.authFailures[failure], 1)
+}
+
+// AuthFailures returns how many clients failed to authenticate for the given reason.
+func (server *Server) AuthFailures(failure AuthFailure) uint64 {
+	return atomic.LoadUint64(&server.authFailures[failure])
+}
+
+// RecordFailure records a client failing during the given phase.
+func (server *Server) RecordFailure(phase Phase) {
+	atomic.AddUint64(&server.failures[phase], 1)
+}
+
+// Failures returns how many clients failed during the given phase.
+func (server *Server) Failures(phase Phase) uint64 {
+	return atomic.LoadUint64(&server.failures[phase])
+}
+
+// RecordConnectionError records a client's connection running into the given error.
+func (server *Server) RecordConnectionError(ce ConnectionError) {
+	atomic.AddUint64(&server.connectionErrors[ce], 1)
+}
+
+// ConnectionErrors returns how many connections ran into the given error.
+func (server *Server) ConnectionErrors(ce ConnectionError) uint64 {
+	return atomic.LoadUint64(&server.connectionErrors[ce])
+}
+
+// RecordLatency records the given latency.
+func (server *Server) RecordLatency(metric LatencyMetric, elapsed time.Duration) {
+	server.latencies[metric].Record(elapsed)
+}
+
+// Latency returns the histogram for the given latency.
+func (server *Server) Latency(metric LatencyMetric) *Histogram {
+	return &server.latencies[metric]
+}
+
+// Registered returns how many clients registered successfully.
+func (server *Server) Registered() uint64 {
+	return server.latencies[LMRegistration].Count()
+}
+
+// Authenticated returns how many clients authenticated successfully.
+func (server *Server) Authenticated() uint64 {
+	return server.latencies[LMAuthentication].Count()
+}
+
+// RecordFloodSent records the given client sending a flood message.
+func (server *Server) RecordFloodSent(sender int) {
+	atomic.AddUint64(&server.floodSent, 1)
+	count, _ := server.floodSentBy.LoadOrStore(sender, new(uint64))
+	atomic.AddUint64(count.(*uint64), 1)
+}
+
+// FloodSentBy returns how many flood messages the given client sent.
+func (server *Server) FloodSentBy(sender int) uint64 {
+	count, exists := server.floodSentBy.Load(sender)
+	if !exists {
+		return 0
+	}
+	return atomic.LoadUint64(count.(*uint64))
+}
+
+// RecordFloodDelivered records a client receiving another client's flood
+// message for the first time.
+func (server *Server) RecordFloodDelivered() {
+	atomic.AddUint64(&server.floodDelivered, 1)
+}
+
+// RecordFloodGaps records a client never receiving the given number of
+// flood messages from senders it heard from.
+func (server *Server) RecordFloodGaps(gaps uint64) {
+	atomic.AddUint64(&server.floodGaps, gaps)
+}
+
+// RecordFloodOutOfOrder records a client receiving a flood message after
+// a later one from the same sender.
+func (server *Server) RecordFloodOutOfOrder() {
+	atomic.AddUint64(&server.floodOutOfOrder, 1)
+}
+
+// RecordFloodDuplicate records a client receiving a flood message again.
+func (server *Server) RecordFloodDuplicate() {
+	atomic.AddUint64(&server.floodDuplicates, 1)
+}
+
+// FloodCorrectness returns the correctness failures seen in flood messages:
+// how many went missing from senders each client heard from, how many
+// arrived out of order and how many arrived more than once.
+func (server *Server) FloodCorrectness() (gaps, outOfOrder, duplicates uint64) {
+	return atomic.LoadUint64(&server.floodGaps), atomic.LoadUint64(&server.floodOutOfOrder), atomic.LoadUint64(&server.floodDuplicates)
+}
+
+// FloodDeliveries returns how many flood messages were sent and delivered
+// (not counting duplicates), and how many deliveries we expected. Every
+// client that joined is expected to receive every flood message sent by
+// the others, so this assumes all clients joined before flooding began.
+func (server *Server) FloodDeliveries() (sent, delivered, expected uint64) {
+	sent = atomic.LoadUint64(&server.floodSent)
+	delivered = atomic.LoadUint64(&server.floodDelivered)
+	if joined := server.latencies[LMJoin].Count(); joined > 0 {
+		expected = sent * (joined - 1)
+	}
+	return
+}
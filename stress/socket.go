@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,9 +18,20 @@ var (
 
 // Socket represents an IRC socket.
 type Socket struct {
-	Closed bool
-	conn   net.Conn
-	reader *bufio.Reader
+	// WriteTimeout is how long a single write may take, if set.
+	WriteTimeout time.Duration
+	conn         net.Conn
+	reader       *bufio.Reader
+
+	// clients fail, and close their socket, from outside the read loop
+	closedLock sync.Mutex
+	closed     bool
+}
+
+// isTimeout returns true if the given error is a network timeout.
+func isTimeout(err error) bool {
+	netErr, isNetErr := err.(net.Error)
+	return isNetErr && netErr.Timeout()
 }
 
 // NewSocket returns a new Socket.
@@ -32,16 +44,26 @@ func NewSocket(conn net.Conn) Socket {
 
 // Close stops a Socket from being able to send/receive any more data.
 func (socket *Socket) Close() {
-	if socket.Closed {
+	socket.closedLock.Lock()
+	if socket.closed {
+		socket.closedLock.Unlock()
 		return
 	}
-	socket.Closed = true
+	socket.closed = true
+	socket.closedLock.Unlock()
 	socket.conn.Close()
 }
 
+// Closed returns true if the Socket has been closed.
+func (socket *Socket) Closed() bool {
+	socket.closedLock.Lock()
+	defer socket.closedLock.Unlock()
+	return socket.closed
+}
+
 // Read returns a single IRC line from a Socket.
 func (socket *Socket) Read() (string, error) {
-	if socket.Closed {
+	if socket.Closed() {
 		return "", io.EOF
 	}
 
@@ -66,11 +88,14 @@ func (socket *Socket) Read() (string, error) {
 
 // Write sends the given string out of Socket.
 func (socket *Socket) Write(data string) error {
-	if socket.Closed {
+	if socket.Closed() {
 		return io.EOF
 	}
 
 	// write data
+	if socket.WriteTimeout != 0 {
+		socket.conn.SetWriteDeadline(time.Now().Add(socket.WriteTimeout))
+	}
 	_, err := socket.conn.Write([]byte(data))
 	if err != nil {
 		socket.Close()
@@ -0,0 +1,236 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// tCritical95 are the two-sided 95% critical values of Student's
+// t-distribution, indexed by degrees of freedom.
+var tCritical95 = []float64{
+	math.Inf(1),
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tCritical returns the two-sided 95% critical t value for the given
+// degrees of freedom, rounding down to stay on the cautious side.
+func tCritical(df float64) float64 {
+	switch {
+	case df < 1:
+		return math.Inf(1)
+	case df < float64(len(tCritical95)):
+		return tCritical95[int(df)]
+	case df < 60:
+		return 2.021
+	case df < 120:
+		return 2.000
+	default:
+		return 1.960
+	}
+}
+
+// MetricStatistics summarises one metric across every run against a server.
+type MetricStatistics struct {
+	Server string     `json:"server"`
+	Metric string     `json:"metric"`
+	Unit   MetricUnit `json:"unit"`
+	Runs   int        `json:"runs"`
+	Mean   float64    `json:"mean"`
+	StdDev float64    `json:"stddev"`
+	Min    float64    `json:"min"`
+	Max    float64    `json:"max"`
+	// CILow and CIHigh are the 95% confidence interval of the mean.
+	CILow  float64 `json:"ci95_low"`
+	CIHigh float64 `json:"ci95_high"`
+
+	// Change is how the mean differs from the first server's, as a
+	// percentage, and Significant is whether that difference is more than
+	// noise according to Welch's t-test.
+	Change      *float64 `json:"change_vs_first,omitempty"`
+	Significant *bool    `json:"significant_vs_first,omitempty"`
+
+	order int
+}
+
+// metric returns the mean as a Metric.
+func (stats *MetricStatistics) metric() Metric {
+	return Metric{
+		Name:  stats.Metric,
+		Value: stats.Mean,
+		Unit:  stats.Unit,
+		order: stats.order,
+	}
+}
+
+// significantlyDifferent returns true if the two means differ by more than
+// we'd expect from noise, using Welch's t-test at the 95% level.
+func significantlyDifferent(a, b *MetricStatistics) bool {
+	if a.Runs < 2 || b.Runs < 2 {
+		return false
+	}
+	va := a.StdDev * a.StdDev / float64(a.Runs)
+	vb := b.StdDev * b.StdDev / float64(b.Runs)
+	if va+vb == 0 {
+		return a.Mean != b.Mean
+	}
+	t := math.Abs(a.Mean-b.Mean) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(a.Runs-1) + vb*vb/float64(b.Runs-1))
+	return t > tCritical(df)
+}
+
+// serverNames returns the name of every server in the report, in order.
+func (report *Report) serverNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, result := range report.Servers {
+		if !seen[result.Name] {
+			seen[result.Name] = true
+			names = append(names, result.Name)
+		}
+	}
+	return names
+}
+
+// serverMetrics returns the given server's metrics, using the mean of every
+// run if there were several.
+func (report *Report) serverMetrics(name string) []Metric {
+	var metrics []Metric
+	if len(report.Statistics) > 0 {
+		for i := range report.Statistics {
+			if report.Statistics[i].Server == name {
+				metrics = append(metrics, report.Statistics[i].metric())
+			}
+		}
+		return metrics
+	}
+	for i := range report.Servers {
+		if report.Servers[i].Name == name {
+			return report.Servers[i].Metrics()
+		}
+	}
+	return nil
+}
+
+// Summarise fills in the report's Statistics from each server's runs. Count
+// metrics missing from a run, like unseen errors, are taken as zero.
+func (report *Report) Summarise() {
+	report.Statistics = nil
+	var firstServer map[string]*MetricStatistics
+
+	for _, name := range report.serverNames() {
+		// every run's value of each metric
+		values := make(map[string][]float64)
+		var summaries []*MetricStatistics
+		var runs int
+		for i := range report.Servers {
+			if report.Servers[i].Name != name {
+				continue
+			}
+			runs++
+			for _, metric := range report.Servers[i].Metrics() {
+				if _, exists := values[metric.Name]; !exists {
+					summaries = append(summaries, &MetricStatistics{
+						Server: name,
+						Metric: metric.Name,
+						Unit:   metric.Unit,
+						order:  metric.order,
+					})
+				}
+				values[metric.Name] = append(values[metric.Name], metric.Value)
+			}
+		}
+
+		byName := make(map[string]*MetricStatistics)
+		for _, stats := range summaries {
+			samples := values[stats.Metric]
+			for stats.Unit == MUCount && len(samples) < runs {
+				samples = append(samples, 0)
+			}
+			stats.Runs = len(samples)
+
+			stats.Min, stats.Max = samples[0], samples[0]
+			var total float64
+			for _, value := range samples {
+				total += value
+				stats.Min = math.Min(stats.Min, value)
+				stats.Max = math.Max(stats.Max, value)
+			}
+			stats.Mean = total / float64(stats.Runs)
+			if stats.Runs > 1 {
+				var squares float64
+				for _, value := range samples {
+					squares += (value - stats.Mean) * (value - stats.Mean)
+				}
+				stats.StdDev = math.Sqrt(squares / float64(stats.Runs-1))
+			}
+			margin := tCritical(float64(stats.Runs-1)) * stats.StdDev / math.Sqrt(float64(stats.Runs))
+			if stats.StdDev == 0 {
+				margin = 0
+			}
+			stats.CILow = stats.Mean - margin
+			stats.CIHigh = stats.Mean + margin
+
+			if firstServer != nil {
+				if first, exists := firstServer[stats.Metric]; exists {
+					significant := significantlyDifferent(stats, first)
+					stats.Significant = &significant
+					if first.Mean != 0 {
+						change := 100 * (stats.Mean - first.Mean) / math.Abs(first.Mean)
+						stats.Change = &change
+					}
+				}
+			}
+			byName[stats.Metric] = stats
+		}
+		if firstServer == nil {
+			firstServer = byName
+		}
+
+		sort.SliceStable(summaries, func(i, j int) bool {
+			return summaries[i].order < summaries[j].order
+		})
+		for _, stats := range summaries {
+			report.Statistics = append(report.Statistics, *stats)
+		}
+	}
+}
+
+// WriteStatistics writes a table for each server summarising its metrics
+// across every run. It writes nothing if there was only one run.
+func (report *Report) WriteStatistics(w io.Writer) {
+	if len(report.Statistics) == 0 {
+		return
+	}
+	for _, name := range report.serverNames() {
+		table := tablewriter.NewWriter(w)
+		table.SetAutoWrapText(false)
+		table.SetAutoFormatHeaders(false)
+		table.SetHeader([]string{name, "Mean", "StdDev", "Min", "Max", "95% CI"})
+		for _, stats := range report.Statistics {
+			if stats.Server != name {
+				continue
+			}
+			format := func(value float64) string {
+				return Metric{Value: value, Unit: stats.Unit}.Format()
+			}
+			table.Append([]string{
+				stats.Metric,
+				format(stats.Mean),
+				format(stats.StdDev),
+				format(stats.Min),
+				format(stats.Max),
+				fmt.Sprintf("%s to %s", format(stats.CILow), format(stats.CIHigh)),
+			})
+		}
+		table.Render()
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// histogramSubBits sets the histogram's precision, each power of two is
+	// split into 2^(histogramSubBits-1) buckets, giving under 2% error.
+	histogramSubBits    = 7
+	histogramHalfBucket = 1 << (histogramSubBits - 1)
+	histogramBuckets    = (64-histogramSubBits+1)*histogramHalfBucket + histogramHalfBucket
+)
+
+// Histogram records durations in log-linear buckets, in the style of
+// HdrHistogram. It's safe to record to from multiple goroutines at once,
+// and never locks.
+type Histogram struct {
+	// all fields are accessed atomically, keep them 64-bit aligned
+	count   uint64
+	total   uint64
+	max     uint64
+	buckets [histogramBuckets]uint64
+}
+
+// bucketIndex returns the bucket the given value goes into.
+func bucketIndex(value uint64) int {
+	if value < 2*histogramHalfBucket {
+		return int(value)
+	}
+	shift := uint(bits.Len64(value) - histogramSubBits)
+	return int(shift)*histogramHalfBucket + int(value>>shift)
+}
+
+// bucketValue returns the middle of the range of values in the given bucket.
+func bucketValue(index int) uint64 {
+	if index < 2*histogramHalfBucket {
+		return uint64(index)
+	}
+	shift := uint(index/histogramHalfBucket - 1)
+	low := uint64(index-int(shift)*histogramHalfBucket) << shift
+	return low + (uint64(1)<<shift)/2
+}
+
+// Record adds the given duration to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	value := uint64(d)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.total, value)
+	atomic.AddUint64(&h.buckets[bucketIndex(value)], 1)
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if value <= max || atomic.CompareAndSwapUint64(&h.max, max, value) {
+			return
+		}
+	}
+}
+
+// Count returns how many durations have been recorded.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Mean returns the mean of the recorded durations.
+func (h *Histogram) Mean() time.Duration {
+	count := atomic.LoadUint64(&h.count)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadUint64(&h.total) / count)
+}
+
+// Max returns the longest recorded duration.
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadUint64(&h.max))
+}
+
+// Percentile returns the duration that the given percentage of recorded
+// durations are at or below, e.g. Percentile(99.9).
+func (h *Histogram) Percentile(percent float64) time.Duration {
+	count := atomic.LoadUint64(&h.count)
+	if count == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(float64(count) * percent / 100))
+	if target < 1 {
+		target = 1
+	}
+
+	max := atomic.LoadUint64(&h.max)
+	var seen uint64
+	for i := range h.buckets {
+		seen += atomic.LoadUint64(&h.buckets[i])
+		if target <= seen {
+			value := bucketValue(i)
+			if max < value {
+				value = max
+			}
+			return time.Duration(value)
+		}
+	}
+	return time.Duration(max)
+}
+
+// LatencyMetric is a latency we measure for each server.
+type LatencyMetric int
+
+const (
+	// LMConnect is how long opening the connection takes.
+	LMConnect LatencyMetric = iota
+	// LMHandshake is how long the TLS handshake takes.
+	LMHandshake
+	// LMRegistration is from sending NICK/USER until RPL_WELCOME (001).
+	LMRegistration
+	// LMAuthentication is from sending AUTHENTICATE until RPL_SASLSUCCESS (903).
+	LMAuthentication
+	// LMJoin is from sending JOIN until the server echoes it back to us.
+	LMJoin
+	// LMPing is from sending PING until the matching PONG.
+	LMPing
+	// LMQuit is from sending QUIT until the server's ERROR.
+	LMQuit
+	// LMDelivery is from sending a flood message until another client receives it.
+	LMDelivery
+	// LMScheduleLag is how late open-loop events were sent.
+	LMScheduleLag
+
+	// NumLatencyMetrics is the number of metrics above.
+	NumLatencyMetrics
+)
+
+var latencyMetricNames = [NumLatencyMetrics]string{
+	LMConnect:        "TCP Connect",
+	LMHandshake:      "TLS Handshake",
+	LMRegistration:   "Registration",
+	LMAuthentication: "Authentication",
+	LMJoin:           "JOIN",
+	LMPing:           "PING",
+	LMQuit:           "QUIT",
+	LMDelivery:       "Channel Delivery",
+	LMScheduleLag:    "Schedule Lag",
+}
+
+// String returns a human-readable name for the metric.
+func (lm LatencyMetric) String() string {
+	if lm < 0 || NumLatencyMetrics <= lm {
+		return "unknown"
+	}
+	return latencyMetricNames[lm]
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	tests := []struct {
+		value uint64
+		want  int
+	}{
+		{0, 0},
+		{1, 1},
+		{127, 127},
+		// from here on each power of two is split into 64 buckets
+		{128, 128},
+		{129, 128},
+		{130, 129},
+		{255, 191},
+		{256, 192},
+		{259, 192},
+		{260, 193},
+		{math.MaxUint64, histogramBuckets - 1},
+	}
+	for _, test := range tests {
+		if index := bucketIndex(test.value); index != test.want {
+			t.Errorf("bucketIndex(%d) = %d, want %d", test.value, index, test.want)
+		}
+	}
+}
+
+func TestBucketValue(t *testing.T) {
+	tests := []struct {
+		index int
+		want  uint64
+	}{
+		{0, 0},
+		{127, 127},
+		{128, 129},
+		{191, 255},
+		{192, 258},
+	}
+	for _, test := range tests {
+		if value := bucketValue(test.index); value != test.want {
+			t.Errorf("bucketValue(%d) = %d, want %d", test.index, value, test.want)
+		}
+	}
+}
+
+func TestBucketRoundTrip(t *testing.T) {
+	values := []uint64{0, 1, 100, 127, 128, 1000, 12345, 999999, uint64(time.Second), uint64(time.Hour), math.MaxUint64 / 3}
+	for _, value := range values {
+		index := bucketIndex(value)
+		if index < 0 || histogramBuckets <= index {
+			t.Errorf("bucketIndex(%d) = %d, out of range", value, index)
+			continue
+		}
+		if 0 < index && bucketIndex(value-1) > index {
+			t.Errorf("bucketIndex isn't monotonic at %d", value)
+		}
+		approx := bucketValue(index)
+		if bucketIndex(approx) != index {
+			t.Errorf("bucketValue(%d) = %d, which is in bucket %d", index, approx, bucketIndex(approx))
+		}
+		if off := math.Abs(float64(approx)-float64(value)) / math.Max(float64(value), 1); off > 0.02 {
+			t.Errorf("bucketValue(bucketIndex(%d)) = %d, %.2f%% off", value, approx, 100*off)
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	var empty Histogram
+	if p := empty.Percentile(99); p != 0 {
+		t.Errorf("empty Percentile(99) = %s, want 0", p)
+	}
+
+	var h Histogram
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	if h.Count() != 100 {
+		t.Errorf("Count() = %d, want 100", h.Count())
+	}
+	if h.Max() != 100*time.Millisecond {
+		t.Errorf("Max() = %s, want 100ms", h.Max())
+	}
+	if mean := h.Mean(); mean != 50500*time.Microsecond {
+		t.Errorf("Mean() = %s, want 50.5ms", mean)
+	}
+
+	tests := []struct {
+		percent float64
+		want    time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{1, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{99.9, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		p := h.Percentile(test.percent)
+		if math.Abs(float64(p-test.want)) > 0.02*float64(test.want) {
+			t.Errorf("Percentile(%g) = %s, want about %s", test.percent, p, test.want)
+		}
+		if p > h.Max() {
+			t.Errorf("Percentile(%g) = %s, more than Max() %s", test.percent, p, h.Max())
+		}
+	}
+
+	var negative Histogram
+	negative.Record(-time.Second)
+	if p := negative.Percentile(50); p != 0 {
+		t.Errorf("Percentile(50) of a negative duration = %s, want 0", p)
+	}
+}
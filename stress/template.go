@@ -0,0 +1,137 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lines and flood text may include template variables, which are expanded
+// each time the line is sent:
+//
+//	{nick}                 the client's current nickname
+//	{id}                   the client's ID
+//	{seq}                  how many lines the client has sent from its script
+//	{chan}                 the event's target channel
+//	{rand_nick}            the nickname of a random client
+//	{random_text:min-max}  between min and max characters of random words
+//	{timestamp}            the current time, like the server-time cap
+//
+// Anything else in braces is sent as-is.
+
+// templateWords are what random text is made of.
+var templateWords = strings.Fields(`
+	lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod
+	tempor incididunt ut labore et dolore magna aliqua irc server client
+	channel message network ping pong join part quit nick topic mode hello
+	there how are you doing today this is a test of the broadcast path
+`)
+
+// parseTextLength parses the min-max length argument of {random_text}. A
+// single number gives an exact length.
+func parseTextLength(arg string) (int, int, error) {
+	minText, maxText := arg, arg
+	if index := strings.IndexByte(arg, '-'); index != -1 {
+		minText, maxText = arg[:index], arg[index+1:]
+	}
+	min, err := strconv.Atoi(minText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid random_text length: %s", arg)
+	}
+	max, err := strconv.Atoi(maxText)
+	if err != nil || min < 0 || max < min {
+		return 0, 0, fmt.Errorf("invalid random_text length: %s", arg)
+	}
+	return min, max, nil
+}
+
+// forEachVariable calls handle with the name and argument of each template
+// variable in the given text, and writes everything else to buf.
+func forEachVariable(text string, buf *strings.Builder, handle func(variable, name, arg string)) {
+	for {
+		start := strings.IndexByte(text, '{')
+		end := strings.IndexByte(text[start+1:], '}')
+		if start == -1 || end == -1 {
+			buf.WriteString(text)
+			return
+		}
+		end += start + 1
+
+		buf.WriteString(text[:start])
+		name, arg := text[start+1:end], ""
+		if index := strings.IndexByte(name, ':'); index != -1 {
+			name, arg = name[:index], name[index+1:]
+		}
+		handle(text[start:end+1], name, arg)
+		text = text[end+1:]
+	}
+}
+
+// ValidateTemplate returns an error if the given text uses template
+// variables incorrectly.
+func ValidateTemplate(text string) error {
+	var buf strings.Builder
+	var err error
+	forEachVariable(text, &buf, func(variable, name, arg string) {
+		if err == nil && name == "random_text" {
+			_, _, err = parseTextLength(arg)
+		}
+	})
+	return err
+}
+
+// randomText returns random words, between min and max characters long.
+func (run *queueRun) randomText(min, max int) string {
+	length := min + run.rand.Intn(max-min+1)
+	var buf strings.Builder
+	for buf.Len() < length {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(templateWords[run.rand.Intn(len(templateWords))])
+	}
+	return buf.String()[:length]
+}
+
+// expand returns the given text with its template variables expanded.
+func (run *queueRun) expand(text string, event Event) string {
+	if strings.IndexByte(text, '{') == -1 {
+		return text
+	}
+
+	var buf strings.Builder
+	forEachVariable(text, &buf, func(variable, name, arg string) {
+		switch name {
+		case "nick":
+			buf.WriteString(run.client.currentNick())
+		case "id":
+			buf.WriteString(strconv.Itoa(run.client.ID))
+		case "seq":
+			buf.WriteString(strconv.Itoa(run.sent))
+		case "chan":
+			buf.WriteString(event.Target)
+		case "rand_nick":
+			if len(run.nicks) == 0 {
+				buf.WriteString(run.client.currentNick())
+			} else {
+				buf.WriteString(run.nicks[run.rand.Intn(len(run.nicks))])
+			}
+		case "random_text":
+			min, max, err := parseTextLength(arg)
+			if err != nil {
+				buf.WriteString(variable)
+			} else {
+				buf.WriteString(run.randomText(min, max))
+			}
+		case "timestamp":
+			buf.WriteString(time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+		default:
+			buf.WriteString(variable)
+		}
+	})
+	return buf.String()
+}
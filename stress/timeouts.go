@@ -0,0 +1,90 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phase is a part of a client's lifetime that it can get stuck in.
+type Phase int
+
+const (
+	// PhaseConnect is opening the connection.
+	PhaseConnect Phase = iota
+	// PhaseHandshake is the TLS handshake.
+	PhaseHandshake
+	// PhaseRegistration is from sending NICK/USER until we're registered.
+	PhaseRegistration
+	// PhaseWait is an ETWait event.
+	PhaseWait
+	// PhasePing is an ETPing event.
+	PhasePing
+	// PhaseWrite is sending data to the server.
+	PhaseWrite
+	// PhaseQuit is from sending QUIT until the server closes the connection.
+	PhaseQuit
+
+	// NumPhases is the number of phases above.
+	NumPhases
+)
+
+var phaseNames = [NumPhases]string{
+	PhaseConnect:      "connect",
+	PhaseHandshake:    "TLS handshake",
+	PhaseRegistration: "registration",
+	PhaseWait:         "wait",
+	PhasePing:         "ping",
+	PhaseWrite:        "write",
+	PhaseQuit:         "quit",
+}
+
+// String returns a human-readable name for the phase.
+func (phase Phase) String() string {
+	if phase < 0 || NumPhases <= phase {
+		return fmt.Sprintf("unknown (%d)", phase)
+	}
+	return phaseNames[phase]
+}
+
+// Timeouts are the deadlines for each phase of a client's lifetime. Zero
+// values are replaced with the matching DefaultTimeouts value.
+type Timeouts struct {
+	Connect      time.Duration
+	Handshake    time.Duration
+	Registration time.Duration
+	Wait         time.Duration
+	Ping         time.Duration
+	Write        time.Duration
+	Quit         time.Duration
+}
+
+// DefaultTimeouts are the timeouts used when none are given.
+var DefaultTimeouts = Timeouts{
+	Connect:      10 * time.Second,
+	Handshake:    handshakeTimeout,
+	Registration: 30 * time.Second,
+	Wait:         30 * time.Second,
+	Ping:         30 * time.Second,
+	Write:        30 * time.Second,
+	Quit:         30 * time.Second,
+}
+
+// withDefaults returns the timeouts with zero values filled in.
+func (t Timeouts) withDefaults() Timeouts {
+	fill := func(value *time.Duration, def time.Duration) {
+		if *value == 0 {
+			*value = def
+		}
+	}
+	fill(&t.Connect, DefaultTimeouts.Connect)
+	fill(&t.Handshake, DefaultTimeouts.Handshake)
+	fill(&t.Registration, DefaultTimeouts.Registration)
+	fill(&t.Wait, DefaultTimeouts.Wait)
+	fill(&t.Ping, DefaultTimeouts.Ping)
+	fill(&t.Write, DefaultTimeouts.Write)
+	fill(&t.Quit, DefaultTimeouts.Quit)
+	return t
+}
@@ -0,0 +1,460 @@
+// Copyright (c) 2016 Daniel Oaks <daniel@danieloaks.net>
+// released under the ISC license
+
+package stress
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// frame is a list of events being run, either the queue's own events or
+// those of an ETRepeat or ETChoice event.
+type frame struct {
+	events []Event
+	next   int
+	// repeats is how many more times to run the events, or -1 to keep
+	// going until the deadline.
+	repeats int
+	// until, if set, stops repeating the events once it's passed.
+	until time.Time
+}
+
+// again returns true if the frame's events should be run again.
+func (f *frame) again(now time.Time) bool {
+	if f.repeats == 0 || len(f.events) == 0 {
+		return false
+	}
+	return f.until.IsZero() || now.Before(f.until)
+}
+
+// queueRun is a single run of an EventQueue against a server.
+type queueRun struct {
+	queue  *EventQueue
+	client *Client
+	// frames are the event lists we're running, innermost last, including
+	// the waits after lines that wait for their reply.
+	frames  []*frame
+	started bool
+	armed   map[*Event]*waiter
+	readyAt time.Time
+	// paceStart and paced schedule open-loop events, see EventQueue.Pace.
+	paceStart time.Time
+	paced     int
+	// parked is true while we're waiting for everyone to be ready to disconnect.
+	parked bool
+	// barrier is the barrier we're waiting at, if any.
+	barrier string
+	done    bool
+
+	// rand makes our random choices, seeded from the queue so that runs can
+	// be repeated exactly.
+	rand *rand.Rand
+	// nicks are the nicknames of every client being run, for {rand_nick}.
+	nicks []string
+	// sent is how many lines we've sent from the script, for {seq}.
+	sent int
+}
+
+func newQueueRun(queue *EventQueue, readyAt time.Time, nicks []string) *queueRun {
+	run := &queueRun{
+		queue:   queue,
+		client:  queue.Client.clone(),
+		frames:  []*frame{{events: queue.Events}},
+		armed:   make(map[*Event]*waiter),
+		readyAt: readyAt,
+		rand:    rand.New(rand.NewSource(queue.seed())),
+		nicks:   nicks,
+	}
+	// the client picks nicks from its read loop, so it needs its own
+	run.client.rand = rand.New(rand.NewSource(run.rand.Int63()))
+	return run
+}
+
+// current returns the frame holding our next event, moving on to the next
+// repeat or out of finished frames as needed. It returns nil once every
+// event has been run.
+func (run *queueRun) current(now time.Time) *frame {
+	for len(run.frames) > 0 {
+		top := run.frames[len(run.frames)-1]
+		if top.next < len(top.events) {
+			return top
+		}
+		if top.again(now) {
+			top.next = 0
+			if top.repeats > 0 {
+				top.repeats--
+			}
+			continue
+		}
+		run.frames = run.frames[:len(run.frames)-1]
+	}
+	return nil
+}
+
+// finished returns true if every event has been run.
+func (run *queueRun) finished() bool {
+	return run.current(time.Now()) == nil
+}
+
+// push starts running the given events, repeating them as given.
+func (run *queueRun) push(events []Event, repeats int, until time.Time) {
+	run.frames = append(run.frames, &frame{
+		events:  events,
+		repeats: repeats,
+		until:   until,
+	})
+}
+
+// armWaits arms the run of ETWait events directly following the given event,
+// so that replies arriving before we reach the wait itself aren't missed.
+func (run *queueRun) armWaits(f *frame, index int) {
+	for i := index + 1; i < len(f.events) && f.events[i].Type == ETWait; i++ {
+		event := &f.events[i]
+		if run.armed[event] == nil {
+			run.armed[event] = run.client.expect(event.Wait)
+		}
+	}
+}
+
+// sleepFor returns how long the given ETSleep event should sleep for.
+func (run *queueRun) sleepFor(event *Event) time.Duration {
+	d := event.Duration
+	if event.Jitter > 0 {
+		d += time.Duration(run.rand.Int63n(int64(2*event.Jitter)+1)) - event.Jitter
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// choose returns one of the given choices, picked at random by weight.
+func (run *queueRun) choose(choices []Choice) *Choice {
+	var total int
+	for _, choice := range choices {
+		total += choice.Weight
+	}
+	if total < 1 {
+		return nil
+	}
+	pick := run.rand.Intn(total)
+	for i := range choices {
+		pick -= choices[i].Weight
+		if pick < 0 {
+			return &choices[i]
+		}
+	}
+	return nil
+}
+
+// abandon releases the sync points in the given events, so that other
+// clients don't wait on us after we've failed.
+func (run *queueRun) abandon(server *Server, events []Event) {
+	for _, event := range events {
+		switch event.Type {
+		case ETDisconnect:
+			server.ClientsReadyToDisconnect.Done()
+		case ETBarrier:
+			server.ReachBarrier(event.Barrier)
+		}
+	}
+}
+
+// schedule returns when the given event was meant to happen. For open-loop
+// events that aren't due yet, it pushes back readyAt and returns false.
+func (run *queueRun) schedule(server *Server, event Event, now time.Time) (time.Time, bool) {
+	if run.queue.Pace == 0 || (event.Type != ETLine && event.Type != ETPing && event.Type != ETFlood) {
+		return now, true
+	}
+
+	if run.paceStart.IsZero() {
+		run.paceStart = now
+	}
+	intended := run.paceStart.Add(time.Duration(run.paced) * run.queue.Pace)
+	if now.Before(intended) {
+		run.readyAt = intended
+		return intended, false
+	}
+	run.paced++
+	server.RecordLatency(LMScheduleLag, now.Sub(intended))
+	return intended, true
+}
+
+// step runs our next event, unless it's an open-loop event that isn't due yet.
+func (run *queueRun) step(server *Server) {
+	client := run.client
+	now := time.Now()
+	f := run.current(now)
+	if f == nil {
+		run.done = !run.parked
+		return
+	}
+	i := f.next
+	event := &f.events[i]
+
+	if !run.started && run.queue.Pace != 0 {
+		// open-loop clients start when they're meant to, so count how late
+		// they actually start
+		server.RecordLatency(LMScheduleLag, now.Sub(run.readyAt))
+	}
+	run.started = true
+
+	intended, due := run.schedule(server, *event, now)
+	if !due {
+		return
+	}
+	client.setIntendedSend(intended)
+	f.next++
+
+	switch event.Type {
+	case ETConnect:
+		log.Println(client.currentNick(), "connecting")
+		run.armWaits(f, i)
+		// failures are recorded by Connect
+		client.Connect(server)
+	case ETDisconnect:
+		// we finish disconnecting once everyone else is ready to, see Worker.Run
+		run.parked = client.readyToDisconnect(server)
+	case ETLine:
+		run.armWaits(f, i)
+		line := run.expand(event.Line, *event)
+		if event.WaitReply {
+			// the waits depend on the filled-in line, so they're run next
+			if waits := WaitsAfter(line); waits != nil {
+				run.push(waits, 0, time.Time{})
+				run.armWaits(run.frames[len(run.frames)-1], -1)
+			}
+		}
+		client.Write(server, line)
+		run.sent++
+	case ETWait:
+		w := run.armed[event]
+		if w == nil {
+			w = client.expect(event.Wait)
+		}
+		delete(run.armed, event)
+		err := client.waitFor(w, server.Timeouts.withDefaults().Wait)
+		if err != nil {
+			client.fail(server, PhaseWait, fmt.Errorf("wait for %s failed: %s", event.Wait.String(), err.Error()))
+		}
+	case ETPing:
+		err := client.Ping(server)
+		if err != nil {
+			client.fail(server, PhasePing, err)
+		}
+	case ETRegister:
+		run.armWaits(f, i)
+		client.Register(server)
+	case ETWaitRegistered:
+		if !client.WaitRegistered() {
+			client.fail(server, PhaseRegistration, errNotRegistered)
+		}
+	case ETFlood:
+		client.Flood(server, event.Target, run.expand(event.Line, *event))
+		run.sent++
+	case ETSleep:
+		// the worker carries on with other queues until we're ready again
+		sleep := run.sleepFor(event)
+		run.readyAt = now.Add(sleep)
+		if !run.paceStart.IsZero() {
+			// open-loop events resume their schedule after the sleep, rather
+			// than bursting to catch up with it
+			run.paceStart = run.paceStart.Add(sleep)
+		}
+	case ETRepeat:
+		if event.Count > 0 || event.Duration > 0 {
+			repeats := event.Count - 1
+			var until time.Time
+			if event.Duration > 0 {
+				until = now.Add(event.Duration)
+				if event.Count < 1 {
+					repeats = -1
+				}
+			}
+			run.push(event.Events, repeats, until)
+		}
+	case ETChoice:
+		if choice := run.choose(event.Choices); choice != nil {
+			run.push(choice.Events, 0, time.Time{})
+		}
+	case ETBarrier:
+		// the worker carries on with other queues until everyone's here
+		server.ReachBarrier(event.Barrier)
+		run.barrier = event.Barrier
+	default:
+		panic(fmt.Sprintf("Unknown event type: %d", event.Type))
+	}
+
+	if client.Failed() {
+		// sync points are only allowed in the queue's own events
+		top := run.frames[0]
+		run.abandon(server, top.events[top.next:])
+		run.frames = nil
+	}
+	run.done = run.finished() && !run.parked && run.barrier == ""
+}
+
+// Worker drives a set of EventQueues against a server. Each step runs one
+// event of one queue, going round-robin between the queues that are ready,
+// so a worker models a single actor handling all of its clients in turn.
+type Worker struct {
+	Queues []*EventQueue
+
+	// nicks are the nicknames of every client being run, for {rand_nick}.
+	nicks []string
+}
+
+// Run goes through our queues' events until they've all finished. If given,
+// offsets holds how long after now each queue should start.
+func (worker *Worker) Run(server *Server, offsets []time.Duration) {
+	start := time.Now()
+	server.startBarriers()
+	active := make([]*queueRun, len(worker.Queues))
+	for i, queue := range worker.Queues {
+		readyAt := start
+		if offsets != nil {
+			readyAt = start.Add(offsets[i])
+		}
+		active[i] = newQueueRun(queue, readyAt, worker.nicks)
+	}
+
+	for len(active) > 0 {
+		now := time.Now()
+		var progressed bool
+		var nextReady time.Time
+		// released is closed when a barrier we're waiting at may be done
+		var released chan struct{}
+		for _, run := range active {
+			if run.parked || run.done {
+				continue
+			}
+			if run.barrier != "" {
+				done, changed := server.barrierReleased(run.barrier)
+				if !done {
+					if released == nil {
+						released = changed
+					}
+					continue
+				}
+				run.barrier = ""
+			}
+			if now.Before(run.readyAt) {
+				if nextReady.IsZero() || run.readyAt.Before(nextReady) {
+					nextReady = run.readyAt
+				}
+				continue
+			}
+			run.step(server)
+			if run.readyAt.After(now) {
+				// open-loop event that isn't due yet
+				if nextReady.IsZero() || run.readyAt.Before(nextReady) {
+					nextReady = run.readyAt
+				}
+			} else {
+				progressed = true
+			}
+		}
+
+		// remove finished runs
+		var parked int
+		remaining := active[:0]
+		for _, run := range active {
+			if run.done {
+				// send finished notice, used for syncing
+				server.ClientsFinished.Done()
+				continue
+			}
+			if run.parked {
+				parked++
+			}
+			remaining = append(remaining, run)
+		}
+		active = remaining
+
+		if 0 < len(active) && parked == len(active) {
+			// everyone left is ready to disconnect, so wait for all other
+			// clients to be as well, then send all our QUITs together
+			server.ClientsReadyToDisconnect.Wait()
+			for _, run := range active {
+				run.client.sendQuit()
+			}
+			// wait for the replies together, so a server that never
+			// answers costs one QUIT timeout rather than one per client
+			var quits sync.WaitGroup
+			for _, run := range active {
+				quits.Add(1)
+				go func(client *Client) {
+					defer quits.Done()
+					client.waitQuit(server)
+				}(run.client)
+			}
+			quits.Wait()
+			for _, run := range active {
+				run.parked = false
+				run.done = run.finished()
+			}
+		} else if !progressed && released != nil {
+			// wait for a barrier or for the next queue to be ready, whichever
+			// comes first
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if !nextReady.IsZero() {
+				timer = time.NewTimer(time.Until(nextReady))
+				timeout = timer.C
+			}
+			select {
+			case <-released:
+			case <-timeout:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+		} else if !progressed && !nextReady.IsZero() {
+			time.Sleep(time.Until(nextReady))
+		}
+	}
+}
+
+// RunWorkers splits the given queues between the given number of workers
+// and runs them against the server, starting each queue as the ramp profile
+// says. Zero workers runs one worker per queue. ClientsFinished is marked
+// done for each queue as it finishes.
+func RunWorkers(server *Server, queues []*EventQueue, workers int, ramp RampProfile) {
+	if workers < 1 || len(queues) < workers {
+		workers = len(queues)
+	}
+
+	nicks := make([]string, len(queues))
+	for i, queue := range queues {
+		nicks[i] = queue.Client.Nick
+	}
+
+	assigned := make([]Worker, workers)
+	offsets := make([][]time.Duration, workers)
+	for i := range assigned {
+		assigned[i].nicks = nicks
+	}
+	for i, queue := range queues {
+		w := i % workers
+		assigned[w].Queues = append(assigned[w].Queues, queue)
+		offsets[w] = append(offsets[w], ramp.Offset(i, len(queues)))
+	}
+
+	// start every worker at once, so offsets line up between them
+	startGate := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := range assigned {
+		go func(i int) {
+			defer wg.Done()
+			<-startGate
+			assigned[i].Run(server, offsets[i])
+		}(i)
+	}
+	close(startGate)
+	wg.Wait()
+}